@@ -0,0 +1,117 @@
+package s3transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AccessRecord describes one request completed by RoundTrip. See WithAccessLog.
+type AccessRecord struct {
+	// Time is when RoundTrip was called.
+	Time time.Time
+	// Method and Host (a URL hostname, without port) are the request's.
+	Method, Host string
+	// IP is the address of the last attempt, or nil if none was made.
+	IP net.IP
+	// StatusCode is the response's status code, or zero if Err is set.
+	StatusCode int
+	// Err is the error RoundTrip returned, if any.
+	Err error
+	// Bytes is the number of response body bytes the caller read.
+	Bytes int64
+	// Duration is the time from Time until the response body was finished (read to EOF,
+	// closed, or abandoned because the request's context was done), or RoundTrip failed.
+	Duration time.Duration
+	// Attempts is the number of attempts made, including retries.
+	Attempts int
+}
+
+// WithAccessLog makes T call f with an AccessRecord for each request, once it's completed:
+// when RoundTrip fails or the response body is finished, or, for upgraded (101) connections,
+// when RoundTrip returns. f must be safe for concurrent use and shouldn't block, since it
+// may be called by the reader of the body.
+func WithAccessLog(f func(AccessRecord)) Option {
+	return func(t *T) { t.accessLog = f }
+}
+
+type accessLogKey struct{}
+
+// accessLog accumulates a request's AccessRecord.
+type accessLog struct {
+	// bytes counts the response body bytes read. It's accessed atomically, and first, for
+	// the alignment that requires.
+	bytes int64
+
+	mu     sync.Mutex
+	record AccessRecord
+}
+
+// startAccessLog returns req, with a context that collects an access record if WithAccessLog
+// is set, and the log, or nil.
+func (t *T) startAccessLog(req *http.Request, start time.Time) (*http.Request, *accessLog) {
+	if t.accessLog == nil {
+		return req, nil
+	}
+	l := &accessLog{record: AccessRecord{Time: start, Method: req.Method, Host: req.URL.Hostname()}}
+	if l.record.Method == "" {
+		l.record.Method = http.MethodGet
+	}
+	return req.WithContext(context.WithValue(req.Context(), accessLogKey{}, l)), l
+}
+
+// recordAccessAttempt notes, in ctx's access log, if any, an attempt sent to ip.
+func recordAccessAttempt(ctx context.Context, ip net.IP) {
+	l, _ := ctx.Value(accessLogKey{}).(*accessLog)
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.record.IP = ip
+	l.record.Attempts++
+	l.mu.Unlock()
+}
+
+// finishAccessLog arranges for l's record to be passed to the WithAccessLog func once the
+// request, whose outcome is resp or err, is finished. It replaces resp's body, if any, to
+// count the bytes read.
+func (t *T) finishAccessLog(ctx context.Context, l *accessLog, resp *http.Response, err error) {
+	finish := func() {
+		l.mu.Lock()
+		record := l.record
+		l.mu.Unlock()
+		record.Bytes = atomic.LoadInt64(&l.bytes)
+		record.Duration = time.Since(record.Time)
+		t.accessLog(record)
+	}
+	if err != nil {
+		l.record.Err = err
+		finish()
+		return
+	}
+	l.record.StatusCode = resp.StatusCode
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// The body is the upgraded connection, which must stay writable, and whose bytes
+		// aren't the response's.
+		finish()
+		return
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, n: &l.bytes}
+	resp.Body = endInFlightWithBody(ctx, resp, finish)
+}
+
+// countingBody counts the bytes read from it in *n, atomically.
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
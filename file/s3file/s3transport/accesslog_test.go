@@ -0,0 +1,57 @@
+package s3transport
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+	records := make(chan AccessRecord, 2)
+	rt := newTestT(srv.factory, testIPs(1), WithAccessLog(func(r AccessRecord) { records <- r }))
+	defer rt.Close()
+
+	start := time.Now()
+	req, err := http.NewRequest(http.MethodPut, "http://s3.example.com/key", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	select {
+	case <-records:
+		t.Fatal("logged before the body was finished")
+	default:
+	}
+	_, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	r := <-records
+	assert.False(t, r.Time.Before(start))
+	assert.Equal(t, http.MethodPut, r.Method)
+	assert.Equal(t, "s3.example.com", r.Host)
+	assert.Equal(t, testIPs(1)[0].String(), r.IP.String())
+	assert.Equal(t, http.StatusAccepted, r.StatusCode)
+	assert.NoError(t, r.Err)
+	assert.EqualValues(t, 5, r.Bytes)
+	assert.True(t, r.Duration > 0 && r.Duration <= time.Since(start))
+	assert.Equal(t, 1, r.Attempts)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = get(canceled, rt, "http://s3.example.com/")
+	require.Error(t, err)
+	r = <-records
+	assert.Equal(t, http.MethodGet, r.Method)
+	assert.Error(t, r.Err)
+	assert.Zero(t, r.StatusCode)
+}
@@ -0,0 +1,69 @@
+package s3transport
+
+import (
+	"net"
+	"net/http"
+)
+
+// maxAffinityPaths bounds the number of paths per host whose last good IP
+// WithLastGoodIPAffinity remembers. When it's exceeded, the host's paths are forgotten.
+const maxAffinityPaths = 1024
+
+// WithLastGoodIPAffinity makes T send idempotent requests (like GET) for a host and URL path
+// to the IP that the last request for them succeeded with, if it's still a candidate, which
+// improves connection reuse and cache locality for workloads that poll the same objects.
+// When a request to that IP fails with a transport error or a retriable status (429 or 5xx),
+// the path's IP is forgotten, and the next request is balanced as usual. It takes precedence
+// over the balancers, but not over exclusions, subnet preferences, or sticky retries.
+func WithLastGoodIPAffinity() Option {
+	return func(t *T) { t.lastGoodAffinity = true }
+}
+
+// chooseAffinity returns the last good IP for req, for host, if it's among candidates.
+// Otherwise, it returns nil.
+func (t *T) chooseAffinity(req *http.Request, host string, candidates []net.IP) net.IP {
+	if !t.lastGoodAffinity || !isIdempotent(req.Method) {
+		return nil
+	}
+	s := t.lookupHost(host)
+	if s == nil {
+		return nil
+	}
+	s.affinityMu.Lock()
+	key, ok := s.affinity[req.URL.Path]
+	s.affinityMu.Unlock()
+	if !ok {
+		return nil
+	}
+	for _, ip := range candidates {
+		if string(ip.To16()) == key {
+			return ip
+		}
+	}
+	return nil
+}
+
+// recordAffinity updates the last good IP for req's path, for host, given the outcome, resp
+// or err, of sending it to ip.
+func (t *T) recordAffinity(req *http.Request, host string, ip net.IP, resp *http.Response, err error) {
+	if !isIdempotent(req.Method) || req.Context().Err() != nil {
+		return
+	}
+	s := t.host(host)
+	key := string(ip.To16())
+	s.affinityMu.Lock()
+	defer s.affinityMu.Unlock()
+	if isRetriable(req.Context(), resp, err) {
+		if s.affinity[req.URL.Path] == key {
+			delete(s.affinity, req.URL.Path)
+		}
+		return
+	}
+	if _, ok := s.affinity[req.URL.Path]; !ok && len(s.affinity) >= maxAffinityPaths {
+		s.affinity = nil
+	}
+	if s.affinity == nil {
+		s.affinity = map[string]string{}
+	}
+	s.affinity[req.URL.Path] = key
+}
@@ -0,0 +1,70 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLastGoodIPAffinity(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var (
+		mu      sync.Mutex
+		refused string
+	)
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if host, _, _ := net.SplitHostPort(addr); host == refused {
+				return nil, errors.New("connection refused")
+			}
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := newTestT(factory, testIPs(1, 2, 3, 4, 5, 6, 7, 8, 9, 10),
+		WithLastGoodIPAffinity(), WithDisableKeepAlives())
+	defer rt.Close()
+
+	getIP := func(url string) (string, error) {
+		var h History
+		_, err := get(RecordAttempts(context.Background(), &h), rt, url)
+		require.Len(t, h.Attempts(), 1)
+		return h.Attempts()[0].IP.String(), err
+	}
+	first, err := getIP("http://s3.example.com/object")
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		ip, err := getIP("http://s3.example.com/object")
+		require.NoError(t, err)
+		assert.Equal(t, first, ip)
+	}
+
+	mu.Lock()
+	refused = first
+	mu.Unlock()
+	ip, err := getIP("http://s3.example.com/object")
+	assert.Error(t, err)
+	assert.Equal(t, first, ip, "the last good IP is tried until it fails")
+	// The balancer may choose the failing IP again, but once a request succeeds, its IP sticks.
+	var second string
+	for err = errors.New("no requests"); err != nil; {
+		second, err = getIP("http://s3.example.com/object")
+	}
+	assert.NotEqual(t, first, second)
+	for i := 0; i < 20; i++ {
+		ip, err := getIP("http://s3.example.com/object")
+		require.NoError(t, err)
+		assert.Equal(t, second, ip)
+	}
+}
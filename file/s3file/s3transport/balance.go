@@ -0,0 +1,140 @@
+package s3transport
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// pickIP chooses which of host's ips to send req to. It also returns the candidates it chose
+// from. IPs that must not be used are removed first, then preferences narrow the remaining
+// set, so a preference never selects an IP that was filtered out.
+func (t *T) pickIP(req *http.Request, host string, ips []net.IP) (ip net.IP, candidates []net.IP, err error) {
+	if candidates, err = t.excludeIPs(req.Context(), ips); err != nil {
+		return nil, nil, err
+	}
+	candidates = retryCandidates(req.Context(), candidates)
+	candidates = preferSubnets(candidates, t.preferredSubnets)
+	if ip := t.chooseAffinity(req, host, candidates); ip != nil {
+		return ip, candidates, nil
+	}
+	if ip := t.chooseColdStart(host, candidates); ip != nil {
+		return ip, candidates, nil
+	}
+	if t.bandwidthBalancing && transferSize(req) >= t.bandwidthLargeMin {
+		return t.chooseByThroughput(host, candidates), candidates, nil
+	}
+	if t.weightDecay > 0 {
+		return t.chooseByWeight(host, candidates), candidates, nil
+	}
+	if t.successWindow > 0 {
+		return t.chooseBySuccessRate(host, candidates), candidates, nil
+	}
+	return t.balancer.Pick(host, candidates), candidates, nil
+}
+
+// Balancer chooses which of a host's candidate IPs a request is sent to. See WithBalancer.
+type Balancer interface {
+	// Pick returns one of candidates, which is non-empty, for a request for host. It must be
+	// safe for concurrent use.
+	Pick(host string, candidates []net.IP) net.IP
+}
+
+// BalancerFunc adapts a function to Balancer.
+type BalancerFunc func(host string, candidates []net.IP) net.IP
+
+// Pick implements Balancer.
+func (f BalancerFunc) Pick(host string, candidates []net.IP) net.IP { return f(host, candidates) }
+
+// RandomBalancer picks one of the candidates uniformly at random. It's T's default.
+var RandomBalancer Balancer = BalancerFunc(func(_ string, candidates []net.IP) net.IP {
+	return chooseRandom(candidates)
+})
+
+// WithBalancer makes T choose among a host's candidate IPs with b, instead of RandomBalancer.
+// Candidates have already been narrowed by exclusions, subnet preferences, and sticky
+// retries. Other balancing options (like WithAdaptiveWeights) take precedence where they
+// apply, and fall back to b when they have no measurements.
+func WithBalancer(b Balancer) Option {
+	return func(t *T) { t.balancer = b }
+}
+
+// chooseRandom picks one of candidates uniformly at random.
+func chooseRandom(candidates []net.IP) net.IP {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// chooseWeighted picks one of candidates at random, with probabilities proportional to
+// weights, which must be positive.
+func chooseWeighted(candidates []net.IP, weights []float64) net.IP {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return candidates[i]
+		}
+		r -= w
+	}
+	return candidates[len(candidates)-1]
+}
+
+// chooseByScore picks one of host's candidates at random, in proportion to score(scores) for
+// each candidate's scores. Candidates without state get 1. If t has no state for host, it
+// falls back to t.balancer.
+func (t *T) chooseByScore(host string, candidates []net.IP, score func(ipScores) float64) net.IP {
+	s := t.lookupHost(host)
+	if s == nil {
+		return t.balancer.Pick(host, candidates)
+	}
+	weights := make([]float64, len(candidates))
+	now := time.Now()
+	for i, ip := range candidates {
+		weights[i] = 1
+		if is := s.lookupIP(ip); is != nil {
+			is.mu.Lock()
+			weights[i] = score(is.scores(now, t.scoreRefresh))
+			is.mu.Unlock()
+		}
+	}
+	return chooseWeighted(candidates, weights)
+}
+
+// recordScore calls update, with host's ip's state locked, with whether req's outcome, resp
+// or err, was a success (neither a transport error nor a retriable status). Outcomes of
+// requests whose callers gave up aren't recorded, since they're not the IP's fault.
+func (t *T) recordScore(
+	req *http.Request, host string, ip net.IP, resp *http.Response, err error,
+	update func(is *ipState, success bool),
+) {
+	if req.Context().Err() != nil {
+		return
+	}
+	is := t.host(host).ip(ip)
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	update(is, !isRetriable(req.Context(), resp, err))
+}
+
+// preferSubnets returns the ips that are within subnets, or all ips if none are.
+func preferSubnets(ips []net.IP, subnets []net.IPNet) []net.IP {
+	if len(subnets) == 0 {
+		return ips
+	}
+	var preferred []net.IP
+	for _, ip := range ips {
+		for i := range subnets {
+			if subnets[i].Contains(ip) {
+				preferred = append(preferred, ip)
+				break
+			}
+		}
+	}
+	if len(preferred) == 0 {
+		return ips
+	}
+	return preferred
+}
@@ -0,0 +1,144 @@
+package s3transport
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throughputWeight is the weight of each new measurement in an IP's moving average throughput.
+const throughputWeight = 0.3
+
+// WithBandwidthBalancing makes T measure each IP's throughput, over request and response
+// bodies of at least measureMinBytes, and send large requests (whose Content-Length or Range
+// spans at least largeMinBytes) to IPs with probability proportional to their throughput.
+// IPs without measurements are treated as being as fast as the fastest measured one, so they
+// get explored. Smaller requests are balanced as usual, since their latency dominates.
+func WithBandwidthBalancing(measureMinBytes, largeMinBytes int64) Option {
+	return func(t *T) {
+		t.bandwidthBalancing = true
+		t.bandwidthMeasureMin = measureMinBytes
+		t.bandwidthLargeMin = largeMinBytes
+	}
+}
+
+// transferSize returns the number of body bytes req is expected to transfer, from its
+// Content-Length or Range header, or zero if that's unknown.
+func transferSize(req *http.Request) int64 {
+	if req.ContentLength > 0 {
+		return req.ContentLength
+	}
+	// Only a single "bytes=first-last" range is recognized.
+	spec := req.Header.Get("Range")
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0
+	}
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return 0
+	}
+	first, err1 := strconv.ParseInt(spec[len("bytes="):dash], 10, 64)
+	last, err2 := strconv.ParseInt(spec[dash+1:], 10, 64)
+	if err1 != nil || err2 != nil || last < first {
+		return 0
+	}
+	return last - first + 1
+}
+
+// chooseByThroughput picks one of candidates with probability proportional to its measured
+// throughput.
+func (t *T) chooseByThroughput(host string, candidates []net.IP) net.IP {
+	s := t.lookupHost(host)
+	if s == nil {
+		return t.balancer.Pick(host, candidates)
+	}
+	weights := make([]float64, len(candidates))
+	now := time.Now()
+	var max float64
+	for i, ip := range candidates {
+		if is := s.lookupIP(ip); is != nil {
+			is.mu.Lock()
+			weights[i] = is.scores(now, t.scoreRefresh).throughput
+			is.mu.Unlock()
+		}
+		if weights[i] > max {
+			max = weights[i]
+		}
+	}
+	if max == 0 {
+		return t.balancer.Pick(host, candidates)
+	}
+	for i := range weights {
+		if weights[i] == 0 {
+			weights[i] = max
+		}
+	}
+	return chooseWeighted(candidates, weights)
+}
+
+// recordThroughput adds a measurement of n bytes transferred in d to ip's throughput.
+func (t *T) recordThroughput(host string, ip net.IP, n int64, d time.Duration) {
+	if n < t.bandwidthMeasureMin || d <= 0 {
+		return
+	}
+	rate := float64(n) / d.Seconds()
+	is := t.host(host).ip(ip)
+	is.mu.Lock()
+	if is.throughput == 0 {
+		is.throughput = rate
+	} else {
+		is.throughput += throughputWeight * (rate - is.throughput)
+	}
+	is.mu.Unlock()
+}
+
+// measureBandwidth records the throughput of hostReq's body, which was sent in attempt a, and
+// arranges for resp's body's throughput to be recorded once it's read.
+func (t *T) measureBandwidth(host string, hostReq *http.Request, resp *http.Response, a Attempt) {
+	if hostReq.ContentLength > 0 {
+		t.recordThroughput(host, a.IP, hostReq.ContentLength, a.Duration)
+	}
+	// Upgraded (101) connections' bodies must stay writable, and their throughput isn't the
+	// IP's.
+	if resp != nil && resp.Body != nil && resp.Body != http.NoBody &&
+		resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body = &measuringBody{
+			ReadCloser: resp.Body,
+			start:      time.Now(),
+			done:       func(n int64, d time.Duration) { t.recordThroughput(host, a.IP, n, d) },
+		}
+	}
+}
+
+// measuringBody counts the bytes read from a response body and reports the count and elapsed
+// time once, at EOF or Close.
+type measuringBody struct {
+	io.ReadCloser
+	start time.Time
+	done  func(n int64, d time.Duration)
+
+	once sync.Once
+	n    int64
+}
+
+func (b *measuringBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *measuringBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *measuringBody) finish() {
+	b.once.Do(func() { b.done(b.n, time.Since(b.start)) })
+}
@@ -0,0 +1,89 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferSize(t *testing.T) {
+	for _, c := range []struct {
+		contentLength int64
+		rangeHeader   string
+		want          int64
+	}{
+		{0, "", 0},
+		{1000, "", 1000},
+		{0, "bytes=0-99", 100},
+		{0, "bytes=100-", 0},
+		{0, "bytes=-100", 0},
+		{0, "bytes=100", 0},
+		{0, "bytes=-500", 0},
+		{0, "bytes=5-1", 0},
+		{0, "bytes=0-9,20-29", 0},
+		{0, "items=0-9", 0},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "https://s3.example.com/", nil)
+		require.NoError(t, err)
+		req.ContentLength = c.contentLength
+		if c.rangeHeader != "" {
+			req.Header.Set("Range", c.rangeHeader)
+		}
+		assert.Equal(t, c.want, transferSize(req), "%+v", c)
+	}
+}
+
+func TestBandwidthBalancing(t *testing.T) {
+	const mb = 1 << 20
+	rt := New(httpTransport.Clone, WithBandwidthBalancing(mb, 8*mb))
+	defer rt.Close()
+	fast, slow := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	for i := 0; i < 5; i++ {
+		rt.recordThroughput("s3.example.com", fast, 16*mb, 100*time.Millisecond)
+		rt.recordThroughput("s3.example.com", slow, 16*mb, time.Second)
+		rt.recordThroughput("s3.example.com", slow, mb/2, time.Hour) // Too small to count.
+	}
+
+	pickFast := func(rangeHeader string) (n int) {
+		req, err := http.NewRequest(http.MethodGet, "https://s3.example.com/", nil)
+		require.NoError(t, err)
+		req.Header.Set("Range", rangeHeader)
+		for i := 0; i < 1000; i++ {
+			ip, _, err := rt.pickIP(req, "s3.example.com", []net.IP{fast, slow})
+			require.NoError(t, err)
+			if ip.Equal(fast) {
+				n++
+			}
+		}
+		return
+	}
+	// Throughputs are 160 MB/s vs. 16MB/s, so the fast IP should get ~90% of large requests.
+	assert.Greater(t, pickFast("bytes=0-16777215"), 800)
+	small := pickFast("bytes=0-1023")
+	assert.Greater(t, small, 400)
+	assert.Less(t, small, 600)
+}
+
+func TestBandwidthMeasurement(t *testing.T) {
+	body := strings.Repeat("x", 1<<20)
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithBandwidthBalancing(1<<19, 1<<30))
+	defer rt.Close()
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	is := rt.lookupHost("s3.example.com").lookupIP(testIPs(1)[0])
+	require.NotNil(t, is)
+	is.mu.Lock()
+	assert.Greater(t, is.throughput, 0.0)
+	is.mu.Unlock()
+}
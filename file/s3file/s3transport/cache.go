@@ -0,0 +1,57 @@
+package s3transport
+
+import (
+	"net"
+	"time"
+)
+
+// IPCache stores the IPs T has learned for each host, so that requests are spread over more
+// of a host's IPs than a single DNS response provides. The default is in-memory and
+// per-T, but an IPCache can share or persist learned IPs (e.g., across a cluster).
+// Implementations must be safe for concurrent use.
+type IPCache interface {
+	// Get returns the unexpired IPs stored for host, or none. Each IP must be returned at
+	// most once, since T chooses among them uniformly.
+	Get(host string) []net.IP
+	// Put adds ips to host's IPs. They should be retained for ttl, but implementations may
+	// drop them sooner. Put must retain IPs previously stored for host that haven't expired.
+	Put(host string, ips []net.IP, ttl time.Duration)
+}
+
+var _ IPCache = (*expiringMap)(nil)
+
+// WithIPCache makes T store learned IPs in c instead of its own in-memory cache. Options that
+// tune the in-memory cache (like WithMaxHosts) and CacheSize don't apply to c.
+func WithIPCache(c IPCache) Option {
+	return func(t *T) { t.ipCache = c }
+}
+
+// WithOnEvict makes T call f when the periodic sweep of its in-memory cache removes a host
+// because all of the host's IPs expired, with the IPs that expired, so that callers may, for
+// example, re-warm the host or log the event. It's called from the sweep's goroutine, without
+// T's locks held, so it should be fast, and may use T. It's not called for hosts evicted by
+// WithMaxHosts, or when WithIPCache is used.
+func WithOnEvict(f func(host string, ips []net.IP)) Option {
+	return func(t *T) { t.onEvict = f }
+}
+
+// cacheIPs records that host resolved to ips, which should be retained for ttl, and returns
+// all the IPs cached for host.
+func (t *T) cacheIPs(host string, ips []net.IP, ttl time.Duration) []net.IP {
+	if t.ipCache == nil {
+		if t.rotationPolicy == RotationMerge {
+			return t.hostIPs.addAndGet(host, ips, ttl, true)
+		}
+		all, rotated := t.hostIPs.rotate(host, ips, ttl)
+		if rotated && t.rotationPolicy == RotationReplace {
+			t.closeIdleConnections(host)
+		}
+		return all
+	}
+	t.ipCache.Put(host, ips, ttl)
+	if cached := t.ipCache.Get(host); len(cached) > 0 {
+		return cached
+	}
+	// The cache may be lossy (or remote and failing). Fresh IPs are still good.
+	return ips
+}
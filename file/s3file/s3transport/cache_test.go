@@ -0,0 +1,115 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeIPCache struct {
+	mu   sync.Mutex
+	ips  map[string][]net.IP
+	gets []string
+	puts []string
+	ttls []time.Duration
+}
+
+func (c *fakeIPCache) Get(host string) []net.IP {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets = append(c.gets, host)
+	return c.ips[host]
+}
+
+func (c *fakeIPCache) Put(host string, ips []net.IP, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.puts = append(c.puts, host)
+	c.ttls = append(c.ttls, ttl)
+	// IPCache implementations keep sets, so repeated puts don't skew balancing.
+	for _, ip := range ips {
+		known := false
+		for _, cached := range c.ips[host] {
+			known = known || cached.Equal(ip)
+		}
+		if !known {
+			c.ips[host] = append(c.ips[host], ip)
+		}
+	}
+}
+
+func TestIPCache(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	cache := fakeIPCache{ips: map[string][]net.IP{
+		// Learned, for example, by another process.
+		"s3.example.com": testIPs(9),
+	}}
+	rt := newTestT(srv.factory, testIPs(1), WithIPCache(&cache))
+	defer rt.Close()
+	for i := 0; i < 20; i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+		rt.CloseIdleConnections()
+	}
+	assert.Len(t, cache.puts, 20)
+	assert.Len(t, cache.gets, 20)
+	assert.Equal(t, expireAfter, cache.ttls[0])
+	assert.ElementsMatch(t, []string{"10.0.0.1", "10.0.0.9"}, dedup(srv.Dialed()))
+
+	hosts, ips := rt.CacheSize()
+	assert.Zero(t, hosts)
+	assert.Zero(t, ips)
+}
+
+func dedup(ss []string) (ret []string) {
+	seen := map[string]bool{}
+	for _, s := range ss {
+		if !seen[s] {
+			seen[s] = true
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+func TestOnEvict(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	type eviction struct {
+		host string
+		ips  []string
+	}
+	var evictions []eviction
+	rt := newTestT(srv.factory, testIPs(1, 2), WithOnEvict(func(host string, ips []net.IP) {
+		var s []string
+		for _, ip := range ips {
+			s = append(s, ip.String())
+		}
+		sort.Strings(s)
+		evictions = append(evictions, eviction{host, s})
+	}))
+	defer rt.Close()
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+
+	now := time.Now()
+	rt.hostIPs.expireOnce(now)
+	assert.Empty(t, evictions, "IPs are unexpired")
+	rt.hostIPs.expireOnce(now.Add(expireAfter + time.Second))
+	assert.Equal(t, []eviction{{"s3.example.com", []string{"10.0.0.1", "10.0.0.2"}}}, evictions)
+}
+
+func TestOnEvictNil(t *testing.T) {
+	m := newExpiringMap(noOpRunPeriodic, time.Now)
+	m.AddAndGet("s3.example.com", testIPs(1))
+	m.expireOnce(time.Now().Add(2 * expireAfter)) // Shouldn't panic.
+	hosts, _ := m.Size()
+	assert.Zero(t, hosts)
+}
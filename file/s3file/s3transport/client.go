@@ -0,0 +1,23 @@
+package s3transport
+
+import "net/http"
+
+// HTTPClient is the interface of the HTTP clients the AWS SDK for Go v2 accepts
+// (aws.HTTPClient). It's declared here so that this package needn't depend on the SDK.
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+var _ HTTPClient = DefaultClient
+
+// NewAWSHTTPClient returns an HTTPClient, suitable for the AWS SDK for Go v2's
+// config.WithHTTPClient, that sends requests through a new T with Default's recommended
+// transport settings and opts. The T's resources are never released, so programs should
+// create few clients and share them. (To Close the T, use New and http.Client instead.)
+func NewAWSHTTPClient(opts ...Option) HTTPClient {
+	return newHTTPClient(httpTransport.Clone, opts...)
+}
+
+func newHTTPClient(factory func() *http.Transport, opts ...Option) *http.Client {
+	return &http.Client{Transport: New(factory, opts...)}
+}
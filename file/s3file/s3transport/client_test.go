@@ -0,0 +1,37 @@
+package s3transport
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	stubResolver := func(t *T) {
+		t.resolver = newResolver(func(string) ([]net.IP, error) { return testIPs(1), nil }, time.Now)
+	}
+	var client HTTPClient = newHTTPClient(srv.factory, stubResolver, WithName("sdk"))
+	defer client.(*http.Client).Transport.(*T).Close()
+
+	var h History
+	req, err := http.NewRequestWithContext(RecordAttempts(context.Background(), &h),
+		http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, []string{"10.0.0.1"}, srv.Dialed())
+	require.Len(t, h.Attempts(), 1)
+	assert.Equal(t, "sdk", h.Attempts()[0].Transport, "options are applied")
+}
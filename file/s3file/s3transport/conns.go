@@ -0,0 +1,140 @@
+package s3transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats are statistics of T's connections to one of a host's IPs.
+type ConnStats struct {
+	IP net.IP
+	// OpenConns is the number of connections to IP that are currently open.
+	OpenConns int64
+	// Conns is the number of connections that have been opened to IP, and Requests the number
+	// of requests sent on them.
+	Conns, Requests uint64
+}
+
+// RequestsPerConn returns the mean number of requests each connection served, or zero if no
+// connections were opened. A high value with OpenConns at the transport's MaxConnsPerHost
+// suggests requests are waiting for connections (see also MetricConnWait).
+func (s ConnStats) RequestsPerConn() float64 {
+	if s.Conns == 0 {
+		return 0
+	}
+	return float64(s.Requests) / float64(s.Conns)
+}
+
+// ConnStats returns statistics of t's connections to each of host's IPs, ordered by IP, or
+// nil if t hasn't sent requests to host. Connections made by the factory's DialTLSContext
+// (or DialTLS) aren't counted.
+func (t *T) ConnStats(host string) []ConnStats {
+	s := t.lookupHost(host)
+	if s == nil {
+		return nil
+	}
+	s.ipsMu.Lock()
+	stats := make([]ConnStats, 0, len(s.ips))
+	for key, is := range s.ips {
+		stats = append(stats, ConnStats{
+			IP:        net.IP(key),
+			OpenConns: atomic.LoadInt64(&is.openConns),
+			Conns:     atomic.LoadUint64(&is.conns),
+			Requests:  atomic.LoadUint64(&is.connRequests),
+		})
+	}
+	s.ipsMu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return bytes.Compare(stats[i].IP, stats[j].IP) < 0 })
+	return stats
+}
+
+// dialFunc is the type of http.Transport.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// transportDial returns transport's dial function, or, if it has none, the one
+// http.Transport would use.
+func transportDial(transport *http.Transport) dialFunc {
+	switch {
+	case transport.DialContext != nil:
+		return transport.DialContext
+	case transport.Dial != nil:
+		dialNoContext := transport.Dial
+		return func(_ context.Context, network, addr string) (net.Conn, error) {
+			return dialNoContext(network, addr)
+		}
+	}
+	return (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+}
+
+// limitDial returns dial, made to respect WithDialRateLimit and WithDialNetwork.
+func (t *T) limitDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if t.dialLimiter != nil {
+			if err := t.dialLimiter.Wait(ctx); err != nil {
+				return nil, fmt.Errorf("s3transport: dial rate limit: %w", err)
+			}
+		}
+		return dial(ctx, t.network(network), addr)
+	}
+}
+
+// instrumentDial makes transport, which is host's, count its connections to each IP and
+// respect WithDialRateLimit.
+func (t *T) instrumentDial(host string, transport *http.Transport) {
+	dial := t.limitDial(transportDial(transport))
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		var ip net.IP
+		if t.connectProxy != "" {
+			// addr is the proxy's; the connection is for the IP it tunnels to, if any.
+			ip = tunnelIPFromContext(ctx)
+		} else if ipStr, _, err := net.SplitHostPort(addr); err == nil {
+			ip = net.ParseIP(ipStr)
+		}
+		if ip == nil {
+			return conn, nil
+		}
+		s := t.host(host).ip(ip)
+		atomic.AddUint64(&s.conns, 1)
+		atomic.AddInt64(&s.openConns, 1)
+		return &countedConn{Conn: conn, s: s}, nil
+	}
+}
+
+// countedConn decrements its IP's count of open connections when it's closed.
+type countedConn struct {
+	net.Conn
+	s    *ipState
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&c.s.openConns, -1) })
+	return c.Conn.Close()
+}
+
+// recordConnRequest counts a request sent to host's ip on a connection, and reports the IP's
+// open connections.
+func (t *T) recordConnRequest(host string, ip net.IP) {
+	s := t.host(host).ip(ip)
+	atomic.AddUint64(&s.connRequests, 1)
+	t.observeIP(MetricOpenConns, host, ip, float64(atomic.LoadInt64(&s.openConns)))
+}
+
+// network returns the network to dial instead of network. See WithDialNetwork.
+func (t *T) network(network string) string {
+	if t.dialNetwork != "" {
+		return t.dialNetwork
+	}
+	return network
+}
@@ -0,0 +1,177 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnStats(t *testing.T) {
+	const maxConns = 2
+	var (
+		rt       *T
+		mu       sync.Mutex
+		maxOpen  int64
+		observed int
+	)
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stats := rt.ConnStats("s3.example.com"); assert.Len(t, stats, 1) {
+			mu.Lock()
+			if stats[0].OpenConns > maxOpen {
+				maxOpen = stats[0].OpenConns
+			}
+			observed++
+			mu.Unlock()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer srv.Close()
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		transport.MaxConnsPerHost = maxConns
+		return transport
+	}
+	var collector recordingCollector
+	rt = newTestT(factory, testIPs(1), WithMetrics(&collector))
+	defer rt.Close()
+	assert.Nil(t, rt.ConnStats("s3.example.com"))
+
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := get(context.Background(), rt, "http://s3.example.com/")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, n, observed)
+	assert.True(t, maxOpen >= 1 && maxOpen <= maxConns, "max open conns: %d", maxOpen)
+	stats := rt.ConnStats("s3.example.com")
+	require.Len(t, stats, 1)
+	assert.Equal(t, "10.0.0.1", stats[0].IP.String())
+	assert.EqualValues(t, n, stats[0].Requests)
+	assert.True(t, stats[0].Conns >= 1 && stats[0].Conns <= maxConns, "conns: %d", stats[0].Conns)
+	assert.True(t, stats[0].RequestsPerConn() >= n/maxConns, "%v", stats[0].RequestsPerConn())
+
+	metrics := collector.Named(MetricOpenConns)
+	require.Len(t, metrics, n)
+	for _, m := range metrics {
+		assert.Equal(t, "10.0.0.1", m.IP)
+		assert.True(t, m.Value >= 1 && m.Value <= maxConns, "%v", m.Value)
+	}
+
+	rt.CloseIdleConnections()
+	assert.Eventually(t, func() bool { return rt.ConnStats("s3.example.com")[0].OpenConns == 0 },
+		5*time.Second, time.Millisecond)
+}
+
+func TestDialRateLimit(t *testing.T) {
+	const perSec = 40
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var (
+		mu    sync.Mutex
+		dials []time.Time
+	)
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			dials = append(dials, time.Now())
+			mu.Unlock()
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := newTestT(factory, testIPs(1, 2, 3), WithDialRateLimit(perSec), WithDisableKeepAlives())
+	defer rt.Close()
+
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := get(context.Background(), rt, "http://s3.example.com/")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, dials, n)
+	sort.Slice(dials, func(i, j int) bool { return dials[i].Before(dials[j]) })
+	const (
+		interval = time.Second / perSec
+		slack    = 10 * time.Millisecond
+	)
+	for i, d := range dials {
+		assert.True(t, d.Sub(dials[0]) >= time.Duration(i)*interval-slack,
+			"dial %d after %v", i, d.Sub(dials[0]))
+	}
+}
+
+func TestDialRateLimitDeadline(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithDialRateLimit(1), WithDisableKeepAlives())
+	defer rt.Close()
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = get(ctx, rt, "http://s3.example.com/")
+	require.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second, "the request doesn't wait past its deadline")
+}
+
+func TestDialRateLimitNotPositive(t *testing.T) {
+	for _, perSec := range []int{0, -1} {
+		rt := New(nil, WithDialRateLimit(10), WithDialRateLimit(perSec))
+		assert.Nil(t, rt.dialLimiter, "perSec %d removes the limit", perSec)
+		rt.Close()
+	}
+}
+
+func TestDialNetwork(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var (
+		mu       sync.Mutex
+		networks []string
+	)
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			networks = append(networks, network)
+			mu.Unlock()
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := newTestT(factory, testIPs(1), WithDialNetwork("tcp4"))
+	defer rt.Close()
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tcp4"}, networks)
+
+	bad := newTestT(factory, testIPs(1), WithDialNetwork("udp"))
+	defer bad.Close()
+	_, err = get(context.Background(), bad, "http://s3.example.com/")
+	assert.EqualError(t, err, `s3transport: unsupported dial network "udp"`)
+}
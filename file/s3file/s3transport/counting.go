@@ -0,0 +1,45 @@
+package s3transport
+
+import (
+	"net"
+	"sync"
+)
+
+// BalancerPick records one call to a Balancer's Pick.
+type BalancerPick struct {
+	Host       string
+	Candidates []net.IP
+	// IP is the IP Pick returned.
+	IP net.IP
+}
+
+// CountingBalancer wraps a Balancer and records each call to its Pick, so tests can check
+// that a balancer is wired up, and what it's asked to choose from. It's safe for concurrent
+// use.
+type CountingBalancer struct {
+	b Balancer
+
+	mu    sync.Mutex
+	picks []BalancerPick
+}
+
+// NewCountingBalancer returns a CountingBalancer that delegates to b.
+func NewCountingBalancer(b Balancer) *CountingBalancer {
+	return &CountingBalancer{b: b}
+}
+
+// Pick implements Balancer.
+func (c *CountingBalancer) Pick(host string, candidates []net.IP) net.IP {
+	ip := c.b.Pick(host, candidates)
+	c.mu.Lock()
+	c.picks = append(c.picks, BalancerPick{host, append([]net.IP(nil), candidates...), ip})
+	c.mu.Unlock()
+	return ip
+}
+
+// Picks returns the calls to Pick so far, in order.
+func (c *CountingBalancer) Picks() []BalancerPick {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]BalancerPick(nil), c.picks...)
+}
@@ -0,0 +1,39 @@
+package s3transport
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountingBalancer(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	balancer := NewCountingBalancer(RandomBalancer)
+	rt := newTestT(srv.factory, testIPs(1, 2, 3), WithBalancer(balancer))
+	defer rt.Close()
+
+	var h History
+	ctx := RecordAttempts(context.Background(), &h)
+	for i := 0; i < 3; i++ {
+		_, err := get(ctx, rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	_, err := get(ExcludeIPs(ctx, testIPs(1)...), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+
+	picks, attempts := balancer.Picks(), h.Attempts()
+	require.Len(t, picks, 4, "Pick is called once per request")
+	require.Len(t, attempts, 4)
+	for i, pick := range picks {
+		assert.Equal(t, "s3.example.com", pick.Host)
+		want := testIPs(1, 2, 3)
+		if i == 3 {
+			want = testIPs(2, 3)
+		}
+		assert.ElementsMatch(t, want, pick.Candidates, "pick %d", i)
+		assert.Equal(t, attempts[i].IP, pick.IP)
+	}
+}
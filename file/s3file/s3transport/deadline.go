@@ -0,0 +1,65 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// ErrConnectDeadline is returned (wrapped) by RoundTrip for requests that didn't get a
+// connection within the deadline set by WithConnectDeadline.
+var ErrConnectDeadline = errors.New("s3transport: connect deadline exceeded")
+
+// WithConnectDeadline makes RoundTrip fail with ErrConnectDeadline if a request's attempt
+// doesn't get a connection, ready to send it, within d: of the request starting, for its
+// first attempt, and of asking for a connection, for retries and resends. This bounds the
+// phases before any of an attempt is sent (resolving, choosing an IP, and dialing and the TLS
+// handshake), so that slow warm-up fails fast, before committing to a send that might get
+// stuck. Unlike WithResponseHeaderTimeout, it doesn't bound the time the server
+// takes to respond. By default, there's no deadline.
+func WithConnectDeadline(d time.Duration) Option {
+	return func(t *T) { t.connectDeadline = d }
+}
+
+// withConnectDeadline returns req with a context that's canceled if req doesn't get a
+// connection within t.connectDeadline, and a function that must be called with its result.
+func (t *T) withConnectDeadline(req *http.Request) (
+	*http.Request, func(*http.Response, error) (*http.Response, error),
+) {
+	if t.connectDeadline <= 0 {
+		return req, func(resp *http.Response, err error) (*http.Response, error) { return resp, err }
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	var exceeded int32
+	timer := time.AfterFunc(t.connectDeadline, func() {
+		atomic.StoreInt32(&exceeded, 1)
+		cancel()
+	})
+	var attempts int32
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			// The first attempt's deadline has been running since the request started.
+			if atomic.AddInt32(&attempts, 1) > 1 {
+				timer.Reset(t.connectDeadline)
+			}
+		},
+		GotConn: func(httptrace.GotConnInfo) { timer.Stop() },
+	})
+	return req.WithContext(ctx), func(resp *http.Response, err error) (*http.Response, error) {
+		timer.Stop()
+		if err != nil {
+			cancel()
+			if atomic.LoadInt32(&exceeded) != 0 {
+				err = fmt.Errorf("%w: %s", ErrConnectDeadline, req.URL.Hostname())
+			}
+			return nil, err
+		}
+		// The response body may still be read using ctx.
+		resp.Body = endInFlightWithBody(ctx, resp, cancel)
+		return resp, nil
+	}
+}
@@ -0,0 +1,97 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectDeadline(t *testing.T) {
+	var requests int64
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+	}))
+	defer srv.Close()
+	release := make(chan struct{})
+	defer close(release)
+	slowFactory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := newTestT(slowFactory, testIPs(1), WithConnectDeadline(50*time.Millisecond))
+	defer rt.Close()
+
+	start := time.Now()
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrConnectDeadline), "%v", err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Zero(t, atomic.LoadInt64(&requests), "nothing was sent")
+
+	rt = newTestT(srv.factory, testIPs(1), WithConnectDeadline(50*time.Millisecond))
+	defer rt.Close()
+	// The deadline doesn't apply once the request has a connection.
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, int64(1), atomic.LoadInt64(&requests))
+}
+
+func TestConnectDeadlineRetry(t *testing.T) {
+	var requests int32
+	srv := newTestServer(throttlingHandler(1, "0", &requests))
+	defer srv.Close()
+	release := make(chan struct{})
+	defer close(release)
+	var dials int32
+	// Only the first dial succeeds, so the retry's connection never comes.
+	hangingFactory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if atomic.AddInt32(&dials, 1) > 1 {
+				select {
+				case <-release:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	policy := retry.MaxRetries(retry.Backoff(time.Millisecond, time.Millisecond, 1), 3)
+	rt := newTestT(hangingFactory, testIPs(1), WithConnectDeadline(100*time.Millisecond),
+		WithRetryPolicy(policy), WithDisableKeepAlives())
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := get(ctx, rt, "http://s3.example.com/")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrConnectDeadline), "the retry's wait is bounded, too: %v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
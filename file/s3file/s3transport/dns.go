@@ -1,6 +1,7 @@
 package s3transport
 
 import (
+	"context"
 	"net"
 	"sync"
 	"time"
@@ -11,16 +12,41 @@ const dnsCacheTime = 5 * time.Second
 type resolverCacheEntry struct {
 	result     []net.IP
 	resolvedAt time.Time
+	// cacheTime is how long result is served from the cache.
+	cacheTime time.Duration
 }
 
 type resolver struct {
-	lookupIP func(host string) ([]net.IP, error)
+	// lookupIP resolves host. It also returns the result's TTL, or zero if that's unknown.
+	lookupIP func(ctx context.Context, host string) ([]net.IP, time.Duration, error)
 	now      func() time.Time
-	cacheMu  sync.Mutex
-	cache    map[string]resolverCacheEntry
+	// cacheTime returns how long to cache a result with the given TTL. If it's nil, results
+	// are cached for dnsCacheTime, whatever their TTL.
+	cacheTime func(ttl time.Duration) time.Duration
+	cacheMu   sync.Mutex
+	cache     map[string]resolverCacheEntry
 }
 
 func newResolver(lookupIP func(host string) ([]net.IP, error), now func() time.Time) *resolver {
+	return newContextResolver(func(_ context.Context, host string) ([]net.IP, error) {
+		return lookupIP(host)
+	}, now)
+}
+
+// newContextResolver is like newResolver, for lookups that take the request's context.
+func newContextResolver(
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error), now func() time.Time,
+) *resolver {
+	return newTTLResolver(func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+		ips, err := lookupIP(ctx, host)
+		return ips, 0, err
+	}, now)
+}
+
+// newTTLResolver is like newContextResolver, for lookups that also return their results' TTLs.
+func newTTLResolver(
+	lookupIP func(ctx context.Context, host string) ([]net.IP, time.Duration, error), now func() time.Time,
+) *resolver {
 	return &resolver{
 		lookupIP: lookupIP,
 		now:      now,
@@ -28,22 +54,78 @@ func newResolver(lookupIP func(host string) ([]net.IP, error), now func() time.T
 	}
 }
 
+// withCacheTime returns a resolver that does r's lookups, with its own cache, whose results
+// are cached for cacheTime(ttl).
+func (r *resolver) withCacheTime(cacheTime func(ttl time.Duration) time.Duration) *resolver {
+	c := newTTLResolver(r.lookupIP, r.now)
+	c.cacheTime = cacheTime
+	return c
+}
+
 var defaultResolver = newResolver(net.LookupIP, time.Now)
 
 func (r *resolver) LookupIP(host string) ([]net.IP, error) {
+	ips, _, err := r.lookupIPCached(context.Background(), host)
+	return ips, err
+}
+
+// lookupIPCached is LookupIP, for a request with context ctx, and also reports whether the
+// result was served from the cache.
+func (r *resolver) lookupIPCached(ctx context.Context, host string) (_ []net.IP, cached bool, _ error) {
 	r.cacheMu.Lock()
 	entry, ok := r.cache[host]
 	r.cacheMu.Unlock()
 	now := r.now()
-	if ok && now.Sub(entry.resolvedAt) < dnsCacheTime {
-		return entry.result, nil
+	if ok && now.Sub(entry.resolvedAt) < entry.cacheTime {
+		return entry.result, true, nil
 	}
-	ips, err := r.lookupIP(host)
+	ips, ttl, err := r.lookupIP(ctx, host)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	cacheTime := dnsCacheTime
+	if r.cacheTime != nil {
+		cacheTime = r.cacheTime(ttl)
+	}
+	r.cacheMu.Lock()
+	r.cache[host] = resolverCacheEntry{ips, now, cacheTime}
+	r.cacheMu.Unlock()
+	return ips, false, nil
+}
+
+// remaining returns how much longer host's cached result will be served from r's cache, or
+// zero if it won't be.
+func (r *resolver) remaining(host string) time.Duration {
+	r.cacheMu.Lock()
+	entry, ok := r.cache[host]
+	r.cacheMu.Unlock()
+	if !ok {
+		return 0
 	}
+	if d := entry.resolvedAt.Add(entry.cacheTime).Sub(r.now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// lastGood returns host's most recent successful lookup result, if it expired from the cache
+// less than grace ago.
+func (r *resolver) lastGood(host string, grace time.Duration) []net.IP {
 	r.cacheMu.Lock()
-	r.cache[host] = resolverCacheEntry{ips, now}
+	entry, ok := r.cache[host]
 	r.cacheMu.Unlock()
-	return ips, nil
+	if !ok || r.now().Sub(entry.resolvedAt) >= entry.cacheTime+grace {
+		return nil
+	}
+	return entry.result
+}
+
+// seed caches ips as host's lookup result, as if it had just been resolved, unless r has
+// already resolved host.
+func (r *resolver) seed(host string, ips []net.IP) {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	if _, ok := r.cache[host]; !ok {
+		r.cache[host] = resolverCacheEntry{ips, r.now(), dnsCacheTime}
+	}
 }
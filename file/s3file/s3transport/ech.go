@@ -0,0 +1,11 @@
+//go:build go1.23
+// +build go1.23
+
+package s3transport
+
+import "crypto/tls"
+
+func setECHConfigList(config *tls.Config, list []byte) error {
+	config.EncryptedClientHelloConfigList = list
+	return nil
+}
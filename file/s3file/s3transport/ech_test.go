@@ -0,0 +1,31 @@
+//go:build go1.23
+// +build go1.23
+
+package s3transport
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestECHConfigList(t *testing.T) {
+	list := []byte("stub ech config list")
+	rt := New(httpTransport.Clone, WithECHConfigList(list))
+	defer rt.Close()
+	for _, host := range []string{"s3.example.com", "s3-us-west-2.example.com"} {
+		hostRT, err := rt.hostRoundTripper(hostKey{host: host})
+		require.NoError(t, err)
+		config := hostRT.(*http.Transport).TLSClientConfig
+		assert.Equal(t, host, config.ServerName)
+		assert.Equal(t, list, config.EncryptedClientHelloConfigList)
+	}
+
+	plain := New(httpTransport.Clone)
+	defer plain.Close()
+	hostRT, err := plain.hostRoundTripper(hostKey{host: "s3.example.com"})
+	require.NoError(t, err)
+	assert.Nil(t, hostRT.(*http.Transport).TLSClientConfig.EncryptedClientHelloConfigList)
+}
@@ -0,0 +1,15 @@
+//go:build !go1.23
+// +build !go1.23
+
+package s3transport
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+// setECHConfigList fails rather than silently connecting without ECH, which the caller asked
+// for to protect the S3 hostname.
+func setECHConfigList(*tls.Config, []byte) error {
+	return errors.New("s3transport: encrypted client hello requires go1.23 or later")
+}
@@ -0,0 +1,94 @@
+package s3transport
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind identifies what an Event reports.
+type EventKind int
+
+const (
+	// EventResolved reports a request's candidate IPs, after its host was resolved and
+	// merged with the cached IPs.
+	EventResolved EventKind = iota + 1
+	// EventPicked reports the IP chosen for an attempt.
+	EventPicked
+	// EventEjected reports an IP that failed its reachability probe (see
+	// WithReachabilityProbe), so it isn't a candidate for the host's requests, unless none of
+	// the host's IPs are reachable.
+	EventEjected
+	// EventRetried reports that an attempt's outcome will be retried, after a delay.
+	EventRetried
+	// EventCompleted reports RoundTrip's outcome.
+	EventCompleted
+)
+
+var eventKindNames = map[EventKind]string{
+	EventResolved:  "resolved",
+	EventPicked:    "picked",
+	EventEjected:   "ejected",
+	EventRetried:   "retried",
+	EventCompleted: "completed",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Event describes a step of a request's routing. Fields that don't apply to Kind are zero.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// Transport is the name of the T. See WithName.
+	Transport string
+	// Host is the request's original (not IP-rewritten) host.
+	Host string
+	// IPs are the candidates, for EventResolved.
+	IPs []net.IP
+	// IP is the chosen IP, for EventPicked, or the unreachable one, for EventEjected.
+	IP net.IP
+	// StatusCode and Err are the outcome, for EventRetried and EventCompleted.
+	StatusCode int
+	Err        error
+	// Duration is the delay before the retry, for EventRetried, and the time RoundTrip
+	// took, for EventCompleted.
+	Duration time.Duration
+}
+
+// WithEventChannel makes T send Events to ch as requests are routed, for stream consumers
+// like live dashboards. Sends never block: if ch isn't ready, the event is dropped and
+// counted in Stats.DroppedEvents. A buffered channel reduces drops.
+func WithEventChannel(ch chan<- Event) Option {
+	return func(t *T) { t.events = ch }
+}
+
+// emit sends e, completed with t's name and the current time, to t's event channel, if any.
+func (t *T) emit(e Event) {
+	if t.events == nil {
+		return
+	}
+	e.Transport, e.Time = t.name, time.Now()
+	select {
+	case t.events <- e:
+	default:
+		atomic.AddUint64(&t.stats.droppedEvents, 1)
+	}
+}
+
+// emitOutcome emits an event of kind for host with the outcome resp, err.
+func (t *T) emitOutcome(kind EventKind, host string, resp *http.Response, err error, d time.Duration) {
+	if t.events == nil {
+		return
+	}
+	e := Event{Kind: kind, Host: host, Err: err, Duration: d}
+	if resp != nil {
+		e.StatusCode = resp.StatusCode
+	}
+	t.emit(e)
+}
@@ -0,0 +1,92 @@
+package s3transport
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventChannel(t *testing.T) {
+	var requests int32
+	srv := newTestServer(throttlingHandler(1, "0", &requests))
+	defer srv.Close()
+	events := make(chan Event, 16)
+	policy := retry.MaxRetries(retry.Backoff(time.Millisecond, time.Millisecond, 1), 3)
+	rt := newTestT(srv.factory, testIPs(1), WithEventChannel(events), WithRetryPolicy(policy),
+		WithName("events"))
+	defer rt.Close()
+
+	resp, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	close(events)
+	var got []Event
+	for e := range events {
+		got = append(got, e)
+	}
+
+	var kinds []string
+	for _, e := range got {
+		kinds = append(kinds, e.Kind.String())
+		assert.Equal(t, "events", e.Transport)
+		assert.Equal(t, "s3.example.com", e.Host)
+		assert.False(t, e.Time.IsZero())
+	}
+	require.Equal(t, []string{"resolved", "picked", "retried", "picked", "completed"}, kinds)
+	assert.Equal(t, testIPs(1), got[0].IPs)
+	assert.Equal(t, testIPs(1)[0], got[1].IP)
+	assert.Equal(t, http.StatusServiceUnavailable, got[2].StatusCode)
+	assert.Equal(t, http.StatusOK, got[4].StatusCode)
+	assert.Zero(t, rt.Stats().DroppedEvents)
+}
+
+func TestEventChannelFull(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	events := make(chan Event) // Never received from.
+	rt := newTestT(srv.factory, testIPs(1), WithEventChannel(events))
+	defer rt.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("RoundTrip blocked on the event channel")
+	}
+	assert.EqualValues(t, 3, rt.Stats().DroppedEvents)
+}
+
+func TestEventEjected(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	events := make(chan Event, 16)
+	rt := newTestT(refusingFactory(srv, testIPs(2)...), testIPs(1, 2),
+		WithReachabilityProbe(time.Second), WithEventChannel(events))
+	defer rt.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	close(events)
+	var ejected []Event
+	for e := range events {
+		if e.Kind == EventEjected {
+			ejected = append(ejected, e)
+		}
+	}
+	require.Len(t, ejected, 1, "the IP is ejected once")
+	assert.Equal(t, "s3.example.com", ejected[0].Host)
+	assert.True(t, ejected[0].IP.Equal(testIPs(2)[0]))
+	assert.Equal(t, "ejected", ejected[0].Kind.String())
+}
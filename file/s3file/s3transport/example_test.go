@@ -0,0 +1,14 @@
+package s3transport_test
+
+import (
+	"github.com/grailbio/base/file/s3file/s3transport"
+)
+
+// ExampleNewAWSHTTPClient shows how to use T with the AWS SDK for Go v2.
+func ExampleNewAWSHTTPClient() {
+	client := s3transport.NewAWSHTTPClient(s3transport.WithMaxHosts(100))
+	// Then, with github.com/aws/aws-sdk-go-v2/config:
+	//
+	//	cfg, err := config.LoadDefaultConfig(ctx, config.WithHTTPClient(client))
+	_ = client
+}
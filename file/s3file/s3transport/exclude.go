@@ -0,0 +1,55 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// ErrAllIPsExcluded is returned by RoundTrip, if WithStrictExclusion is set, when ExcludeIPs
+// excluded all of a host's candidate IPs.
+var ErrAllIPsExcluded = errors.New("s3transport: all candidate ips are excluded")
+
+type excludedIPsKey struct{}
+
+// ExcludeIPs returns a context that makes RoundTrip avoid sending the request to any of ips.
+// This is useful for higher-level retry logic that knows an IP just failed for the request.
+// Exclusions accumulate if ExcludeIPs is applied to a context that already has some.
+func ExcludeIPs(ctx context.Context, ips ...net.IP) context.Context {
+	excluded := map[string]struct{}{}
+	for ip := range excludedIPs(ctx) {
+		excluded[ip] = struct{}{}
+	}
+	for _, ip := range ips {
+		excluded[string(ip.To16())] = struct{}{}
+	}
+	return context.WithValue(ctx, excludedIPsKey{}, excluded)
+}
+
+// excludedIPs returns the set of IPs (as string(net.IP.To16())) excluded for ctx, or nil.
+func excludedIPs(ctx context.Context) map[string]struct{} {
+	excluded, _ := ctx.Value(excludedIPsKey{}).(map[string]struct{})
+	return excluded
+}
+
+// excludeIPs removes ctx's excluded IPs from ips. If that would remove every IP, it returns
+// either the original list or ErrAllIPsExcluded, depending on t.strictExclusion.
+func (t *T) excludeIPs(ctx context.Context, ips []net.IP) ([]net.IP, error) {
+	excluded := excludedIPs(ctx)
+	if len(excluded) == 0 {
+		return ips, nil
+	}
+	remaining := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if _, ok := excluded[string(ip.To16())]; !ok {
+			remaining = append(remaining, ip)
+		}
+	}
+	if len(remaining) > 0 {
+		return remaining, nil
+	}
+	if t.strictExclusion {
+		return nil, ErrAllIPsExcluded
+	}
+	return ips, nil
+}
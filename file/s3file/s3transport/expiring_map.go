@@ -1,8 +1,11 @@
 package s3transport
 
 import (
+	"container/list"
 	"flag"
+	"fmt"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -24,59 +27,276 @@ const (
 var autologPeriod = flag.Duration("s3file.transport_log_period", 0,
 	"Interval for logging s3transport metrics. Zero disables logging.")
 
+// expiringMap is the default IPCache. It retains IPs in memory until they expire.
 type expiringMap struct {
 	now func() time.Time
+	// done is closed to stop the background expiry and logging loops.
+	done chan struct{}
+	// name labels log lines. See WithName.
+	name string
+
+	// maxHosts, if positive, bounds len(elems). Least recently used hosts are evicted first.
+	maxHosts int
+	// maxIPsPerHost, if positive, bounds the size of each of elems' values. The IPs that
+	// expire first, which were seen least recently, are evicted first.
+	maxIPsPerHost int
+	// onLRUEvict, if not nil, is called (without s.mu held) with hosts evicted to respect
+	// maxHosts.
+	onLRUEvict func(host string)
+	// onExpire, if not nil, is called (without s.mu held) with hosts removed by expireOnce
+	// and the IPs that expired with them.
+	onExpire func(host string, ips []net.IP)
+	// onExpireAge, if not nil, is called (without s.mu held) for each host removed by
+	// expireOnce, with the time since it was added and since it was last used.
+	onExpireAge func(host string, age, idle time.Duration)
+	// afterExpire, if not nil, is called (without s.mu held) at the end of each expireOnce.
+	afterExpire func()
 
 	mu sync.Mutex
-	// elems is URL host -> string(net.IP) -> last seen.
+	// elems is URL host -> string(net.IP) -> expiration time.
 	elems map[string]map[string]time.Time
+	// lru orders the hosts in elems from most to least recently used. hostLRU indexes it.
+	lru     list.List
+	hostLRU map[string]*list.Element
+	// hostTimes records when each host in elems was added and last used.
+	hostTimes map[string]hostTimes
+}
+
+type hostTimes struct {
+	added, used time.Time
+	// overlapped is when the host last resolved to one of its IPs other than the pending
+	// ones, or, if it never has, when it was added or its IPs were rotated. pending holds the
+	// IPs, keyed by elemKey, first seen since. See rotate.
+	overlapped time.Time
+	pending    map[string]bool
 }
 
 func newExpiringMap(runPeriodic runPeriodic, now func() time.Time) *expiringMap {
-	s := expiringMap{now: now, elems: map[string]map[string]time.Time{}}
-	go runPeriodic(expireLoopEvery, s.expireOnce)
+	s := makeExpiringMap(now)
+	s.start(runPeriodic)
+	return s
+}
+
+// makeExpiringMap returns an expiringMap whose background loops aren't started, so that it
+// may be configured first.
+func makeExpiringMap(now func() time.Time) *expiringMap {
+	return &expiringMap{
+		now:       now,
+		done:      make(chan struct{}),
+		elems:     map[string]map[string]time.Time{},
+		hostLRU:   map[string]*list.Element{},
+		hostTimes: map[string]hostTimes{},
+	}
+}
+
+func (s *expiringMap) start(runPeriodic runPeriodic) {
+	runPeriodic(expireLoopEvery, s.expireOnce, s.done)
 	if *autologPeriod > 0 {
-		go runPeriodic(*autologPeriod, s.logOnce)
+		runPeriodic(*autologPeriod, s.logOnce, s.done)
 	}
-	return &s
 }
 
+// Close stops the background loops. It must be called at most once.
+func (s *expiringMap) Close() {
+	close(s.done)
+}
+
+// AddAndGet records that host resolved to newIPs and returns all of host's unexpired IPs. See
+// addAndGet for the result of concurrent calls.
 func (s *expiringMap) AddAndGet(host string, newIPs []net.IP) (allIPs []net.IP) {
-	now := s.now()
+	return s.addAndGet(host, newIPs, expireAfter, true)
+}
+
+// Put implements IPCache. ttl replaces expireAfter as the lifetime of ips.
+func (s *expiringMap) Put(host string, ips []net.IP, ttl time.Duration) {
+	_ = s.addAndGet(host, ips, ttl, false)
+}
+
+// Get implements IPCache.
+func (s *expiringMap) Get(host string) []net.IP {
+	return s.addAndGet(host, nil, 0, true)
+}
+
+// addAndGet records that host resolved to newIPs, which expire after ttl, and, if get,
+// returns all of host's unexpired IPs.
+//
+// Concurrent calls, including those for lookups that returned different IPs, are serialized:
+// host's IPs are the union of all calls' newIPs, with each IP listed once and expiring ttl
+// after the last call (in lock order) that included it.
+func (s *expiringMap) addAndGet(host string, newIPs []net.IP, ttl time.Duration, get bool) (allIPs []net.IP) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	allIPs, evicted := s.add(host, newIPs, ttl, get)
+	s.mu.Unlock()
+	s.evicted(evicted)
+	return
+}
+
+// add is addAndGet, with s.mu held. It returns the hosts evicted to respect maxHosts, which
+// must be passed to evicted after s.mu is released.
+func (s *expiringMap) add(
+	host string, newIPs []net.IP, ttl time.Duration, get bool,
+) (allIPs []net.IP, evicted []string) {
+	// The time is read with s.mu held so that expiration times don't go backwards when
+	// calls race.
+	now := s.now()
+	expiresAt := now.Add(ttl)
 	ips, ok := s.elems[host]
-	if !ok {
+	switch {
+	case ok:
+		s.lru.MoveToFront(s.hostLRU[host])
+		times := s.hostTimes[host]
+		times.used = now
+		s.hostTimes[host] = times
+	case len(newIPs) == 0:
+		// Don't create empty entries for lookups.
+	default:
 		ips = map[string]time.Time{}
 		s.elems[host] = ips
+		s.hostLRU[host] = s.lru.PushFront(host)
+		s.hostTimes[host] = hostTimes{added: now, used: now, overlapped: now}
+		for s.maxHosts > 0 && len(s.elems) > s.maxHosts {
+			oldest := s.lru.Back().Value.(string)
+			s.deleteHost(oldest)
+			evicted = append(evicted, oldest)
+		}
 	}
 	for _, ip := range newIPs {
-		ips[string(ip)] = now
+		ips[elemKey(ip)] = expiresAt
 	}
-	for ip := range ips {
-		allIPs = append(allIPs, net.IP(ip))
+	if s.maxIPsPerHost > 0 && len(ips) > s.maxIPsPerHost {
+		deleteOldest(ips, len(ips)-s.maxIPsPerHost)
+	}
+	if get {
+		// IPs may have expired since the last sweep.
+		for ip, expiresAt := range ips {
+			if now.Before(expiresAt) {
+				allIPs = append(allIPs, net.IP(ip))
+			}
+		}
 	}
 	return
 }
 
+// evicted calls onLRUEvict for hosts returned by add. s.mu must not be held.
+func (s *expiringMap) evicted(hosts []string) {
+	if s.onLRUEvict != nil {
+		for _, host := range hosts {
+			s.onLRUEvict(host)
+		}
+	}
+}
+
+// elemKey returns ip's key in elems' values. IPv4 addresses are keyed by their 4-byte form,
+// whichever form they're given in, so that each address is recorded once.
+func elemKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return string(v4)
+	}
+	return string(ip)
+}
+
+// Size returns the number of hosts and the total number of IPs cached.
+func (s *expiringMap) Size() (hosts, ips int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.elems {
+		ips += len(e)
+	}
+	return len(s.elems), ips
+}
+
+// snapshot returns each host's unexpired IPs, without marking the hosts used.
+func (s *expiringMap) snapshot() map[string][]net.IP {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hosts := make(map[string][]net.IP, len(s.elems))
+	for host, ips := range s.elems {
+		for ip, expiresAt := range ips {
+			if now.Before(expiresAt) {
+				hosts[host] = append(hosts[host], net.IP(ip))
+			}
+		}
+	}
+	return hosts
+}
+
+// export returns the unexpired IPs of each host.
+func (s *expiringMap) export() []CacheEntry {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []CacheEntry
+	for host, ips := range s.elems {
+		for ip, expiresAt := range ips {
+			if now.Before(expiresAt) {
+				entries = append(entries, CacheEntry{Host: host, IP: net.IP(ip), Expires: expiresAt})
+			}
+		}
+	}
+	return entries
+}
+
+// deleteHost removes host. s.mu must be held.
+func (s *expiringMap) deleteHost(host string) {
+	delete(s.elems, host)
+	delete(s.hostTimes, host)
+	if e, ok := s.hostLRU[host]; ok {
+		s.lru.Remove(e)
+		delete(s.hostLRU, host)
+	}
+}
+
 func (s *expiringMap) expireOnce(now time.Time) {
-	earliestUnexpiredTime := now.Add(-expireAfter)
+	type expiredHost struct {
+		host  string
+		ips   []net.IP
+		times hostTimes
+	}
+	var expired []expiredHost
 	s.mu.Lock()
 	for host, ips := range s.elems {
-		deleteBefore(ips, earliestUnexpiredTime)
+		deleted := deleteBefore(ips, now)
 		if len(ips) == 0 {
-			delete(s.elems, host)
+			expired = append(expired, expiredHost{host, deleted, s.hostTimes[host]})
+			s.deleteHost(host)
 		}
 	}
 	s.mu.Unlock()
+	for _, e := range expired {
+		if s.onExpire != nil {
+			s.onExpire(e.host, e.ips)
+		}
+		if s.onExpireAge != nil {
+			s.onExpireAge(e.host, now.Sub(e.times.added), now.Sub(e.times.used))
+		}
+	}
+	if s.afterExpire != nil {
+		s.afterExpire()
+	}
 }
 
-func deleteBefore(times map[string]time.Time, threshold time.Time) {
+// deleteBefore deletes the IPs that expire before threshold and returns them.
+func deleteBefore(times map[string]time.Time, threshold time.Time) (deleted []net.IP) {
 	for key, time := range times {
 		if time.Before(threshold) {
 			delete(times, key)
+			deleted = append(deleted, net.IP(key))
 		}
 	}
+	return
+}
+
+// deleteOldest deletes the n IPs that expire first.
+func deleteOldest(times map[string]time.Time, n int) {
+	keys := make([]string, 0, len(times))
+	for key := range times {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return times[keys[i]].Before(times[keys[j]]) })
+	for _, key := range keys[:n] {
+		delete(times, key)
+	}
 }
 
 func (s *expiringMap) logOnce(time.Time) {
@@ -92,23 +312,33 @@ func (s *expiringMap) logOnce(time.Time) {
 		}
 	}
 	s.mu.Unlock()
-	log.Printf("s3file transport: hosts:%d ips:%d hostipmax:%d", hosts, ips, hostIPMax)
+	var name string
+	if s.name != "" {
+		name = fmt.Sprintf(" name:%s", s.name)
+	}
+	log.Printf("s3file transport:%s hosts:%d ips:%d hostipmax:%d", name, hosts, ips, hostIPMax)
 }
 
-// runPeriodic runs the given func with the given period.
-type runPeriodic func(time.Duration, func(time.Time))
+// runPeriodic arranges for tick to be called with the given period until done is closed.
+// It doesn't block.
+type runPeriodic func(period time.Duration, tick func(time.Time), done <-chan struct{})
 
-func runPeriodicForever() runPeriodic {
-	return func(period time.Duration, tick func(time.Time)) {
-		ticker := time.NewTicker(period)
-		defer ticker.Stop()
-		for {
-			select {
-			case now := <-ticker.C:
-				tick(now)
+// runPeriodicUntilDone returns a runPeriodic that starts a goroutine for each func.
+func runPeriodicUntilDone() runPeriodic {
+	return func(period time.Duration, tick func(time.Time), done <-chan struct{}) {
+		go func() {
+			ticker := time.NewTicker(period)
+			defer ticker.Stop()
+			for {
+				select {
+				case now := <-ticker.C:
+					tick(now)
+				case <-done:
+					return
+				}
 			}
-		}
+		}()
 	}
 }
 
-func noOpRunPeriodic(time.Duration, func(time.Time)) {}
+func noOpRunPeriodic(time.Duration, func(time.Time), <-chan struct{}) {}
@@ -1,7 +1,9 @@
 package s3transport
 
 import (
+	"fmt"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -38,3 +40,100 @@ func TestExpiringMap(t *testing.T) {
 
 	m.logOnce(stubNow) // No assertions other than it shouldn't panic.
 }
+
+func TestExpiringMapMaxHosts(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	m := newExpiringMap(noOpRunPeriodic, func() time.Time { return now })
+	m.maxHosts = 2
+	var evicted []string
+	m.onLRUEvict = func(host string) { evicted = append(evicted, host) }
+
+	ip := []net.IP{{1, 2, 3, 4}}
+	m.AddAndGet("a.example.com", ip)
+	m.AddAndGet("b.example.com", ip)
+	m.AddAndGet("a.example.com", ip) // Now b is least recently used.
+	m.AddAndGet("c.example.com", ip)
+	m.AddAndGet("d.example.com", ip)
+	assert.Equal(t, []string{"b.example.com", "a.example.com"}, evicted)
+	hosts, ips := m.Size()
+	assert.Equal(t, 2, hosts)
+	assert.Equal(t, 2, ips)
+}
+
+func TestExpiringMapPutGet(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	m := newExpiringMap(noOpRunPeriodic, func() time.Time { return now })
+	assert.Empty(t, m.Get("s3.example.com"))
+	m.Put("s3.example.com", []net.IP{{1, 1, 1, 1}}, time.Minute)
+	m.Put("s3.example.com", []net.IP{{2, 2, 2, 2}}, time.Hour)
+	assert.ElementsMatch(t, []net.IP{{1, 1, 1, 1}, {2, 2, 2, 2}}, m.Get("s3.example.com"))
+	m.expireOnce(now.Add(time.Minute + 1))
+	assert.ElementsMatch(t, []net.IP{{2, 2, 2, 2}}, m.Get("s3.example.com"))
+	hosts, _ := m.Size()
+	assert.Equal(t, 1, hosts)
+}
+
+func TestExpiringMapSnapshot(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	s := makeExpiringMap(func() time.Time { return now })
+	s.Put("a.example.com", testIPs(1), time.Minute)
+	s.Put("a.example.com", testIPs(2), time.Hour)
+	s.Put("b.example.com", testIPs(3), time.Minute)
+	assert.Len(t, s.snapshot(), 2)
+
+	// The IPs expire before a sweep removes them.
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, map[string][]net.IP{"a.example.com": testIPs(2)}, s.snapshot())
+}
+
+func TestExpiringMapMaxIPsPerHost(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	m := newExpiringMap(noOpRunPeriodic, func() time.Time { return now })
+	m.maxIPsPerHost = 3
+
+	for i := byte(1); i <= 10; i++ {
+		now = now.Add(time.Second)
+		got := m.AddAndGet("s3.example.com", []net.IP{{10, 0, 0, i}})
+		assert.True(t, len(got) <= 3, "%d: %v", i, got)
+	}
+	assert.ElementsMatch(t, []net.IP{{10, 0, 0, 8}, {10, 0, 0, 9}, {10, 0, 0, 10}},
+		m.AddAndGet("s3.example.com", nil), "the most recently seen IPs are retained")
+
+	now = now.Add(time.Second)
+	m.AddAndGet("s3.example.com", []net.IP{{10, 0, 0, 8}}) // Seen again, so now newest.
+	now = now.Add(time.Second)
+	assert.ElementsMatch(t, []net.IP{{10, 0, 0, 8}, {10, 0, 0, 10}, {10, 0, 0, 11}},
+		m.AddAndGet("s3.example.com", []net.IP{{10, 0, 0, 11}}))
+}
+
+func TestExpiringMapConcurrentAddAndGet(t *testing.T) {
+	const n = 50
+	m := newExpiringMap(noOpRunPeriodic, time.Now)
+	var (
+		wg   sync.WaitGroup
+		want []net.IP
+	)
+	for i := 0; i < n; i++ {
+		// Each lookup returns its own IP and one shared with the next, in both forms.
+		own := net.IP{10, 0, 0, byte(i)}
+		shared := net.ParseIP(fmt.Sprintf("10.0.1.%d", i/2))
+		if i%2 == 0 {
+			shared = shared.To4()
+		}
+		want = append(want, own)
+		if i%2 == 0 {
+			want = append(want, shared)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got := m.AddAndGet("s3.example.com", []net.IP{own, shared})
+			assert.Contains(t, got, own)
+		}()
+	}
+	wg.Wait()
+	assert.ElementsMatch(t, want, m.AddAndGet("s3.example.com", nil))
+	hosts, ips := m.Size()
+	assert.Equal(t, 1, hosts)
+	assert.Equal(t, len(want), ips)
+}
@@ -0,0 +1,72 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/grailbio/base/log"
+)
+
+// WithFallback makes T resend a request that fails in its specialized path (name resolution,
+// IP selection, or the connection to the chosen IP) through a plain transport from factory,
+// which resolves and dials the request's host itself, as http.DefaultTransport does. It's a
+// safety net for networks T is incompatible with; each fallback is logged. Only requests that
+// never got a connection are resent, so no request is executed twice. Requests that were
+// canceled, are for paused hosts, were refused by WithStrictExclusion, or whose bodies can't
+// be rewound are not resent either. By default, errors are returned to the caller.
+func WithFallback() Option {
+	return func(t *T) { t.fallback = true }
+}
+
+type connectedKey struct{}
+
+// withConnectedFlag returns req with a context that makes its attempts set *connected when
+// they get a connection. See shouldFallBack.
+func withConnectedFlag(req *http.Request, connected *int32) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), connectedKey{}, connected))
+}
+
+// markConnected records, for the request using ctx, that one of its attempts got a connection.
+func markConnected(ctx context.Context) {
+	if connected, ok := ctx.Value(connectedKey{}).(*int32); ok {
+		atomic.StoreInt32(connected, 1)
+	}
+}
+
+// shouldFallBack reports whether req, which failed with err, should be resent through the
+// fallback transport. connected is whether any of its attempts got a connection, and so
+// might have been executed.
+func (t *T) shouldFallBack(req *http.Request, err error, connected bool) bool {
+	return t.fallback && !connected &&
+		req.URL.Hostname() != "" && net.ParseIP(req.URL.Hostname()) == nil &&
+		req.Context().Err() == nil &&
+		!errors.Is(err, ErrHostPaused) && !errors.Is(err, ErrAllIPsExcluded) &&
+		isReplayable(req)
+}
+
+// fallBack resends req, which failed with err, through the fallback transport.
+func (t *T) fallBack(req *http.Request, err error) (*http.Response, error) {
+	log.Printf("s3transport: %s: falling back to default transport after error: %v", req.URL.Host, err)
+	fallbackReq, rewindErr := rewound(req.Context(), req)
+	if rewindErr != nil {
+		return nil, err
+	}
+	return t.fallbackRoundTripper().RoundTrip(fallbackReq)
+}
+
+// fallbackRoundTripper returns the fallback transport, creating it if necessary.
+func (t *T) fallbackRoundTripper() http.RoundTripper {
+	t.hostRTsMu.Lock()
+	defer t.hostRTsMu.Unlock()
+	if t.fallbackRT == nil {
+		transport := t.factory()
+		if t.disableKeepAlives {
+			transport.DisableKeepAlives = true
+		}
+		t.fallbackRT = transport
+	}
+	return t.fallbackRT
+}
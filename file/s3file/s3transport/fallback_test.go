@@ -0,0 +1,99 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackOnResolverFailure(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	lookupFailing := func(string) ([]net.IP, error) { return nil, errors.New("resolver unavailable") }
+
+	rt := newTestT(srv.factory, nil)
+	defer rt.Close()
+	rt.resolver = newResolver(lookupFailing, time.Now)
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.Error(t, err, "without WithFallback, the error is returned")
+
+	rt = newTestT(srv.factory, nil, WithFallback())
+	defer rt.Close()
+	rt.resolver = newResolver(lookupFailing, time.Now)
+	resp, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"s3.example.com"}, srv.Dialed(), "the fallback dials the host itself")
+}
+
+func TestFallbackOnRewriteFailure(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	// IP-addressed dials fail, as if the network only allowed connections by name.
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if host, _, _ := net.SplitHostPort(addr); net.ParseIP(host) != nil {
+				return nil, errors.New("connection refused")
+			}
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := newTestT(factory, testIPs(1), WithFallback())
+	defer rt.Close()
+
+	resp, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"s3.example.com"}, srv.Dialed())
+
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	assert.NoError(t, err, "the fallback is used again for later failures")
+}
+
+func TestNoFallbackWhenPaused(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithFallback())
+	defer rt.Close()
+
+	rt.Pause("s3.example.com")
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	assert.True(t, errors.Is(err, ErrHostPaused), "%v", err)
+	assert.Empty(t, srv.Dialed())
+}
+
+func TestNoFallbackAfterSending(t *testing.T) {
+	var requests int32
+	// The server reads the request, then fails mid-response.
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		_, _ = buf.WriteString("HTTP/1.1 200 OK\r\nContent-Le")
+		_ = buf.Flush()
+		_ = conn.Close()
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithFallback())
+	defer rt.Close()
+
+	req, err := http.NewRequest(http.MethodPost, "http://s3.example.com/bucket/key?uploads",
+		strings.NewReader("body"))
+	require.NoError(t, err)
+	_, err = rt.RoundTrip(req)
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requests), "the post isn't resent")
+}
@@ -0,0 +1,69 @@
+package s3transport
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// fanOutDrainLimit bounds how much of each diagnostic response body is read (to allow
+// connection reuse) before it's closed.
+const fanOutDrainLimit = 64 << 10
+
+// WithDebugFanOut enables a diagnostic mode in which idempotent requests (GET, HEAD, and
+// OPTIONS without a body) whose context has a History (see RecordAttempts) are sent to every
+// candidate IP, not just the chosen one. The chosen IP's response is returned; the others are
+// discarded, but their outcomes are recorded in the History with Attempt.Primary false. This
+// helps spot a single misbehaving frontend. It multiplies load, so it's not for production.
+func WithDebugFanOut() Option {
+	return func(t *T) { t.debugFanOut = true }
+}
+
+func isFanOutSafe(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
+
+// fanOut sends hostReq, whose trace is at, to primary and, concurrently, copies of it to the
+// rest of candidates. The copies use ctx, the original request's context, so they don't affect
+// at. fanOut returns primary's response after the others complete.
+func (t *T) fanOut(
+	ctx context.Context, rt http.RoundTripper, hostReq *http.Request, host string,
+	primary net.IP, candidates []net.IP, h *History, at *attemptTrace,
+) (*http.Response, error) {
+	var wg sync.WaitGroup
+	for _, ip := range candidates {
+		if ip.Equal(primary) {
+			continue
+		}
+		ipReq := hostReq.Clone(t.withTunnelIP(ctx, hostReq, ip))
+		ipReq.URL.Host = ipHost(ip, hostReq.URL.Port())
+		wg.Add(1)
+		go func(ip net.IP) {
+			defer wg.Done()
+			start := time.Now()
+			ipRT, err := t.ipRoundTripper(rt, ipReq, host, ip)
+			var resp *http.Response
+			if err == nil {
+				resp, err = ipRT.RoundTrip(ipReq)
+			}
+			a := t.newAttempt(ipReq, host, ip, start, resp, err)
+			a.Primary = false
+			h.add(a)
+			if err == nil {
+				_, _ = io.CopyN(ioutil.Discard, resp.Body, fanOutDrainLimit)
+				_ = resp.Body.Close()
+			}
+		}(ip)
+	}
+	resp, err := t.send(rt, hostReq, host, primary, at)
+	wg.Wait()
+	return resp, err
+}
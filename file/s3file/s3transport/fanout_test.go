@@ -0,0 +1,69 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugFanOut(t *testing.T) {
+	// Each IP is served by a different server returning a different status.
+	statuses := map[string]int{"10.0.0.1": 200, "10.0.0.2": 500, "10.0.0.3": 503}
+	addrs := map[string]string{}
+	for ip, status := range statuses {
+		status := status
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		defer srv.Close()
+		addrs[ip] = srv.Listener.Addr().String()
+	}
+	factory := func() *http.Transport {
+		return &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				host, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				var d net.Dialer
+				return d.DialContext(ctx, network, addrs[host])
+			},
+		}
+	}
+	rt := newTestT(factory, testIPs(1, 2, 3), WithDebugFanOut())
+	defer rt.Close()
+
+	var h History
+	resp, err := get(RecordAttempts(context.Background(), &h), rt, "http://s3.example.com/key")
+	require.NoError(t, err)
+
+	attempts := h.Attempts()
+	require.Len(t, attempts, 3)
+	var primaries int
+	got := map[string]int{}
+	for _, a := range attempts {
+		require.NoError(t, a.Err)
+		got[a.IP.String()] = a.StatusCode
+		if a.Primary {
+			primaries++
+			assert.Equal(t, resp.StatusCode, a.StatusCode)
+		}
+	}
+	assert.Equal(t, 1, primaries)
+	assert.Equal(t, statuses, got)
+
+	// Without a History, or for non-idempotent requests, there's no fan-out.
+	h = History{}
+	req, err := http.NewRequestWithContext(RecordAttempts(context.Background(), &h),
+		http.MethodDelete, "http://s3.example.com/key", nil)
+	require.NoError(t, err)
+	resp, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Len(t, h.Attempts(), 1)
+}
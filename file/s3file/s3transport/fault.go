@@ -0,0 +1,91 @@
+package s3transport
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault is a failure injected into an attempt, for chaos testing (see WithFaultInjection).
+// It's called instead of sending the attempt. If it returns a response or an error, that's
+// the attempt's result. If it returns neither, the attempt is sent normally.
+type Fault func(req *http.Request) (*http.Response, error)
+
+// errInjectedDial is the cause of DialErrorFault's errors.
+var errInjectedDial = errors.New("s3transport: injected dial fault")
+
+// DialErrorFault returns a Fault that fails attempts as if the connection couldn't be made.
+func DialErrorFault() Fault {
+	return func(req *http.Request) (*http.Response, error) {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errInjectedDial}
+	}
+}
+
+// DelayFault returns a Fault that delays attempts by d (or until the request is canceled),
+// then sends them normally.
+func DelayFault(d time.Duration) Fault {
+	return func(req *http.Request) (*http.Response, error) {
+		if err := sleep(req.Context(), d); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+}
+
+// StatusFault returns a Fault that responds to attempts with the given status code (for
+// example, http.StatusServiceUnavailable), without sending them.
+func StatusFault(code int) Fault {
+	return func(req *http.Request) (*http.Response, error) {
+		body := http.StatusText(code)
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+			StatusCode:    code,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"text/plain"}},
+			Body:          ioutil.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+}
+
+// WithFaultInjection makes T inject fault into a random fraction rate (between 0 and 1) of
+// attempts, before they're sent. It's for validating that callers handle transport failures,
+// and should not be used in production. By default, no faults are injected.
+func WithFaultInjection(rate float64, fault Fault) Option {
+	return func(t *T) {
+		t.faultRate = rate
+		t.fault = fault
+	}
+}
+
+// faultInjector decides which attempts get a fault.
+type faultInjector struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func (f *faultInjector) inject(rate float64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64() < rate
+}
+
+// roundTripWithFaults sends hostReq with rt, unless a fault is injected instead.
+func (t *T) roundTripWithFaults(rt http.RoundTripper, hostReq *http.Request) (*http.Response, error) {
+	if t.fault != nil && t.faults.inject(t.faultRate) {
+		if resp, err := t.fault(hostReq); resp != nil || err != nil {
+			closeBody(hostReq)
+			return resp, err
+		}
+	}
+	return rt.RoundTrip(hostReq)
+}
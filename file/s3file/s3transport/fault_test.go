@@ -0,0 +1,65 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjectionRate(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1),
+		WithFaultInjection(0.25, StatusFault(http.StatusServiceUnavailable)))
+	defer rt.Close()
+	rt.faults.rand = rand.New(rand.NewSource(1))
+
+	const n = 400
+	var injected int
+	for i := 0; i < n; i++ {
+		resp, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+		switch resp.StatusCode {
+		case http.StatusServiceUnavailable:
+			assert.Equal(t, "503 Service Unavailable", resp.Status)
+			injected++
+		case http.StatusOK:
+		default:
+			t.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+	}
+	assert.InDelta(t, n/4, injected, n/20)
+	assert.Len(t, srv.Dialed(), 1, "injected faults don't reach the server")
+}
+
+func TestDialErrorFault(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithFaultInjection(1, DialErrorFault()))
+	defer rt.Close()
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	var opErr *net.OpError
+	require.True(t, errors.As(err, &opErr), "%v", err)
+	assert.Equal(t, "dial", opErr.Op)
+	assert.Empty(t, srv.Dialed())
+}
+
+func TestNoFaultInjectionByDefault(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+
+	for i := 0; i < 10; i++ {
+		resp, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,19 @@
+package s3transport
+
+import "context"
+
+type freshConnKey struct{}
+
+// UseFreshConnection returns a context that makes RoundTrip send the request using the
+// context on a new connection, rather than one from the idle pool, and close the connection
+// afterwards; for example, after rotating credentials, so that a possibly poisoned pooled
+// connection isn't reused. Other requests still use the pool. Unlike WithDisableKeepAlives,
+// it applies only to such requests.
+func UseFreshConnection(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshConnKey{}, true)
+}
+
+func freshConnFromContext(ctx context.Context) bool {
+	fresh, _ := ctx.Value(freshConnKey{}).(bool)
+	return fresh
+}
@@ -0,0 +1,32 @@
+package s3transport
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUseFreshConnection(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	require.Len(t, srv.Dialed(), 1)
+
+	for i := 0; i < 2; i++ {
+		_, err = get(UseFreshConnection(context.Background()), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	assert.Len(t, srv.Dialed(), 3, "flagged requests dial despite the idle connection")
+
+	for i := 0; i < 3; i++ {
+		_, err = get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	assert.Len(t, srv.Dialed(), 3, "unflagged requests reuse the pool")
+}
@@ -0,0 +1,120 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attempt describes one request T sent to a specific IP.
+type Attempt struct {
+	// IP is the address the request was sent to.
+	IP net.IP
+	// StatusCode is the response's status code, or zero if Err is set.
+	StatusCode int
+	// Err is the error returned by the underlying transport, if any.
+	Err error
+	// Start is when the attempt started.
+	Start time.Time
+	// Duration is the time until response headers (or an error) were received.
+	Duration time.Duration
+	// Reused is whether the attempt was sent on a connection reused from the pool.
+	Reused bool
+	// Transport is the name of the T that made the attempt. See WithName.
+	Transport string
+	// Primary is false for attempts made only for diagnosis, whose responses were discarded
+	// (see WithDebugFanOut).
+	Primary bool
+	// URL is the request's URL as sent, with its host rewritten to IP and the values of
+	// presigned URLs' credential parameters (see redactedParams) redacted, and Host the Host
+	// header sent with it. ServerName is the TLS server name verified, or empty for plain
+	// http:// requests. They're for debugging T's rewriting.
+	URL, Host, ServerName string
+}
+
+// newAttempt returns the Attempt that sent ipReq, which is for host and addressed to ip.
+func (t *T) newAttempt(
+	ipReq *http.Request, host string, ip net.IP, start time.Time, resp *http.Response, err error,
+) Attempt {
+	a := Attempt{
+		IP: ip, Err: err, Start: start, Duration: time.Since(start), Transport: t.name, Primary: true,
+		URL: redactURL(ipReq.URL), Host: ipReq.Host,
+	}
+	if ipReq.URL.Scheme == "https" {
+		a.ServerName = t.serverName(host, ip)
+	}
+	if resp != nil {
+		a.StatusCode = resp.StatusCode
+	}
+	return a
+}
+
+// redactedParams are the query parameters, in lower case, of presigned URLs whose values are
+// credentials, or grant access.
+var redactedParams = map[string]bool{
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+}
+
+// redactURL returns u as a string, with the values of redactedParams replaced by "REDACTED".
+// The query's other parameters, and their order, are kept.
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		rawName := param
+		if eq := strings.IndexByte(param, '='); eq >= 0 {
+			rawName = param[:eq]
+		}
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		if redactedParams[strings.ToLower(name)] {
+			params[i] = rawName + "=REDACTED"
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(params, "&")
+	return redacted.String()
+}
+
+// History records the attempts T makes for requests whose context was set up with
+// RecordAttempts. It's safe for concurrent use.
+type History struct {
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+// Attempts returns the attempts recorded so far, in the order they completed.
+func (h *History) Attempts() []Attempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Attempt(nil), h.attempts...)
+}
+
+func (h *History) add(a Attempt) {
+	h.mu.Lock()
+	h.attempts = append(h.attempts, a)
+	h.mu.Unlock()
+}
+
+type historyKey struct{}
+
+// RecordAttempts returns a context that makes T record the attempts it makes, for requests
+// using the context, in h.
+func RecordAttempts(ctx context.Context, h *History) context.Context {
+	return context.WithValue(ctx, historyKey{}, h)
+}
+
+func historyFromContext(ctx context.Context) *History {
+	h, _ := ctx.Value(historyKey{}).(*History)
+	return h
+}
@@ -0,0 +1,167 @@
+package s3transport
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hostState is the state T keeps for each host, other than its transports and IPs.
+type hostState struct {
+	// requests and errors are the counters reported by HostStats. They're first, for the
+	// alignment atomic access requires.
+	requests, errors uint64
+
+	slowMu sync.Mutex
+	// slowest holds up to T.slowestPerHost requests, in descending order of duration.
+	slowest []SlowRequest
+
+	spreadMu sync.Mutex
+	// spreadOrder is the shuffled order in which the first spreadPicks requests were assigned
+	// IPs. See WithColdStartSpread.
+	spreadOrder []net.IP
+	spreadPicks int
+
+	affinityMu sync.Mutex
+	// affinity is URL path -> the IP (as string(net.IP.To16())) its last request succeeded
+	// with. See WithLastGoodIPAffinity.
+	affinity map[string]string
+
+	// probeMu serializes updates of probes, which holds the probeResults of
+	// WithReachabilityProbe, as a map from string(net.IP.To16()). The map is replaced, never
+	// modified, so it can be read without locking.
+	probeMu sync.Mutex
+	probes  atomic.Value
+
+	ipsMu sync.Mutex
+	// ips is string(net.IP.To16()) -> state, for IPs that have state.
+	ips map[string]*ipState
+}
+
+// ipState is the state T keeps for each of a host's IPs.
+type ipState struct {
+	// inFlight counts requests (including their response bodies) to the IP that haven't
+	// finished. It and the other counters are first, for the alignment atomic access requires.
+	inFlight int64
+	// openConns counts open connections to the IP, conns the connections ever opened, and
+	// connRequests the requests sent on them. See ConnStats.
+	openConns           int64
+	conns, connRequests uint64
+
+	mu sync.Mutex
+	// ipScores are updated as requests complete.
+	ipScores
+	// snapshot is the copy of ipScores that balancers use if WithScoreRefreshInterval is set.
+	// It was taken at snapshotAt.
+	snapshot   ipScores
+	snapshotAt time.Time
+	// outcomes is a ring of the IP's most recent outcomes (true for success), whose next
+	// element to overwrite is nextOutcome. See WithSuccessRateBalancing.
+	outcomes    []bool
+	nextOutcome int
+	// latency is a moving average of attempt durations. See IPStates.
+	latency time.Duration
+}
+
+// ipScores are the measurements balancers choose IPs by.
+type ipScores struct {
+	// throughput is a moving average of transfer rates, in bytes/second, or zero if there
+	// have been no measurements. See WithBandwidthBalancing.
+	throughput float64
+	// weight is the IP's adaptive weight, if weighted. See WithAdaptiveWeights.
+	weight   float64
+	weighted bool
+	// successRate is the fraction of outcomes that succeeded, if rated. See
+	// WithSuccessRateBalancing.
+	successRate float64
+	rated       bool
+}
+
+// scores returns the scores balancers should use at now: the current ones, or, if refresh is
+// positive, a snapshot that's updated at most once every refresh. is.mu must be held.
+func (is *ipState) scores(now time.Time, refresh time.Duration) ipScores {
+	if refresh <= 0 {
+		return is.ipScores
+	}
+	if is.snapshotAt.IsZero() || now.Sub(is.snapshotAt) >= refresh {
+		is.snapshot, is.snapshotAt = is.ipScores, now
+	}
+	return is.snapshot
+}
+
+// host returns host's state, creating it if necessary.
+func (t *T) host(host string) *hostState {
+	t.hostsMu.Lock()
+	defer t.hostsMu.Unlock()
+	s, ok := t.hosts[host]
+	if !ok {
+		s = &hostState{ips: map[string]*ipState{}}
+		t.hosts[host] = s
+	}
+	return s
+}
+
+// lookupHost returns host's state, or nil if there's none.
+func (t *T) lookupHost(host string) *hostState {
+	t.hostsMu.Lock()
+	defer t.hostsMu.Unlock()
+	return t.hosts[host]
+}
+
+// ip returns ip's state, creating it if necessary.
+func (s *hostState) ip(ip net.IP) *ipState {
+	key := string(ip.To16())
+	s.ipsMu.Lock()
+	defer s.ipsMu.Unlock()
+	is, ok := s.ips[key]
+	if !ok {
+		is = &ipState{}
+		s.ips[key] = is
+	}
+	return is
+}
+
+// lookupIP returns ip's state, or nil if there's none.
+func (s *hostState) lookupIP(ip net.IP) *ipState {
+	s.ipsMu.Lock()
+	defer s.ipsMu.Unlock()
+	return s.ips[string(ip.To16())]
+}
+
+// pruneState discards the state of the IPs that are no longer cached for their hosts, except
+// those with requests in flight or open connections, so that T's state doesn't grow with
+// every IP a host ever resolved to. Hosts' own state, like their counters (see HostStats),
+// is kept. Expired lookup failures (see WithNegativeCacheTTL) are discarded, too. It runs
+// after each cache sweep.
+func (t *T) pruneState() {
+	t.negative.prune(t.resolver.now())
+	var cached map[string][]net.IP
+	if t.ipCache == nil {
+		cached = t.hostIPs.snapshot()
+	}
+	t.hostsMu.Lock()
+	hosts := make(map[string]*hostState, len(t.hosts))
+	for host, s := range t.hosts {
+		hosts[host] = s
+	}
+	t.hostsMu.Unlock()
+	for host, s := range hosts {
+		ips := cached[host]
+		if t.ipCache != nil {
+			ips = t.ipCache.Get(host)
+		}
+		keep := make(map[string]bool, len(ips))
+		for _, ip := range ips {
+			keep[string(ip.To16())] = true
+		}
+		s.ipsMu.Lock()
+		for key, is := range s.ips {
+			if !keep[key] && atomic.LoadInt64(&is.inFlight) == 0 && atomic.LoadInt64(&is.openConns) == 0 {
+				delete(s.ips, key)
+			}
+		}
+		s.ipsMu.Unlock()
+		s.forgetProbes(t.hostIPs.now().Add(-expireAfter))
+	}
+}
@@ -0,0 +1,75 @@
+package s3transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// startInFlight counts a request to host's ip as in flight and returns a func that ends it.
+// Only the first call to the func has effect.
+func (t *T) startInFlight(host string, ip net.IP) (end func()) {
+	s := t.host(host).ip(ip)
+	atomic.AddInt64(&s.inFlight, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { atomic.AddInt64(&s.inFlight, -1) })
+	}
+}
+
+// endInFlightWithBody arranges for end to be called when resp, a response to a request with
+// context ctx, is finished: when its body is read to EOF or closed, or, since callers may never
+// close a body, when ctx is done. It returns resp's replacement body.
+//
+// Bodies of upgraded (101 Switching Protocols) responses are hijacked connections, which
+// the transport no longer manages, so their requests end immediately.
+func endInFlightWithBody(ctx context.Context, resp *http.Response, end func()) io.ReadCloser {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		end()
+		return resp.Body
+	}
+	b := &inFlightBody{ReadCloser: resp.Body, end: end, done: make(chan struct{})}
+	if ctxDone := ctx.Done(); ctxDone != nil {
+		go func() {
+			select {
+			case <-ctxDone:
+				b.finish()
+			case <-b.done:
+			}
+		}()
+	}
+	return b
+}
+
+// inFlightBody ends its request's in-flight count, once, at EOF, Close, or finish.
+type inFlightBody struct {
+	io.ReadCloser
+	end func()
+
+	once sync.Once
+	// done is closed by finish.
+	done chan struct{}
+}
+
+func (b *inFlightBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *inFlightBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *inFlightBody) finish() {
+	b.once.Do(func() {
+		b.end()
+		close(b.done)
+	})
+}
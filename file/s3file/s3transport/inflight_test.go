@@ -0,0 +1,159 @@
+package s3transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func inFlight(rt *T, host string) int64 {
+	return atomic.LoadInt64(&rt.host(host).ip(testIPs(1)[0]).inFlight)
+}
+
+func TestInFlightEndsOnClose(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, inFlight(rt, "s3.example.com"), "the body is unread")
+	require.NoError(t, resp.Body.Close())
+	assert.EqualValues(t, 0, inFlight(rt, "s3.example.com"))
+	require.NoError(t, resp.Body.Close())
+	assert.EqualValues(t, 0, inFlight(rt, "s3.example.com"), "ends are counted once")
+}
+
+func TestInFlightEndsOnCancel(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.EqualValues(t, 1, inFlight(rt, "s3.example.com"))
+
+	cancel() // The body is never closed.
+	assert.Eventually(t, func() bool { return inFlight(rt, "s3.example.com") == 0 },
+		5*time.Second, time.Millisecond)
+}
+
+// newUpgradeServer returns a server that upgrades every request's connection, and then
+// echoes what it reads.
+func newUpgradeServer(t *testing.T) *testServer {
+	return newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		_ = buf.Flush()
+		_, _ = io.Copy(conn, buf) // Echo until the client closes.
+	}))
+}
+
+// upgrade sends an upgrade request through rt, and checks that the upgraded connection is
+// still writable.
+func upgrade(t *testing.T, rt *T) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	_, ok := resp.Body.(io.ReadWriteCloser)
+	assert.True(t, ok, "the hijacked connection is still writable")
+	return resp
+}
+
+func TestInFlightEndsOnUpgrade(t *testing.T) {
+	srv := newUpgradeServer(t)
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+
+	resp := upgrade(t, rt)
+	assert.EqualValues(t, 0, inFlight(rt, "s3.example.com"))
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestAccessLogUpgrade(t *testing.T) {
+	srv := newUpgradeServer(t)
+	defer srv.Close()
+	records := make(chan AccessRecord, 1)
+	rt := newTestT(srv.factory, testIPs(1), WithAccessLog(func(r AccessRecord) { records <- r }))
+	defer rt.Close()
+
+	resp := upgrade(t, rt)
+	defer func() { _ = resp.Body.Close() }()
+	r := <-records
+	assert.Equal(t, http.StatusSwitchingProtocols, r.StatusCode, "the upgrade is logged when it's made")
+}
+
+func TestPruneState(t *testing.T) {
+	release := make(chan struct{})
+	blocking := blockingBodyHandler(release)
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "blocking.example.com" {
+			blocking.ServeHTTP(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	rt := newTestT(srv.factory, testIPs(1), WithHostsMap(map[string][]net.IP{"mapped.test": testIPs(2)}))
+	defer rt.Close()
+
+	for _, url := range []string{"http://s3.example.com/", "http://mapped.test/", "http://10.0.0.5/"} {
+		_, err := get(context.Background(), rt, url)
+		require.NoError(t, err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://blocking.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.NotNil(t, rt.lookupHost("s3.example.com").lookupIP(testIPs(1)[0]))
+
+	// Everything expires, but the blocking request is still in flight, and s3.example.com's
+	// connection is still pooled.
+	rt.hostIPs.expireOnce(time.Now().Add(2 * expireAfter))
+	assert.NotNil(t, rt.lookupHost("s3.example.com").lookupIP(testIPs(1)[0]))
+	assert.EqualValues(t, 1, inFlight(rt, "blocking.example.com"))
+
+	rt.closeIdleConnections("s3.example.com")
+	assert.Eventually(t, func() bool {
+		rt.pruneState()
+		return rt.lookupHost("s3.example.com").lookupIP(testIPs(1)[0]) == nil
+	}, 5*time.Second, time.Millisecond)
+	for _, host := range []string{"s3.example.com", "mapped.test", "10.0.0.5"} {
+		requests, _ := rt.HostStats(host)
+		assert.EqualValues(t, 1, requests, "hosts' counters are kept: %s", host)
+	}
+}
+
+func TestBandwidthUpgrade(t *testing.T) {
+	srv := newUpgradeServer(t)
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithBandwidthBalancing(1, 1<<30))
+	defer rt.Close()
+
+	resp := upgrade(t, rt)
+	require.NoError(t, resp.Body.Close())
+}
@@ -0,0 +1,172 @@
+package s3transport
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// latencyWeight is the weight of each new measurement in an IP's moving average latency.
+const latencyWeight = 0.3
+
+// IPHealth classifies an IP in IPStateView.
+type IPHealth int
+
+const (
+	// IPHealthy IPs are candidates for their host's requests.
+	IPHealthy IPHealth = iota
+	// IPEjected IPs resolved for their host but failed their reachability probe (see
+	// WithReachabilityProbe), so they aren't sent requests until they're probed again, an
+	// hour later, or they're reset by ResetIP.
+	IPEjected
+	// IPDraining IPs are no longer cached for their host, but still have requests in flight.
+	IPDraining
+)
+
+var ipHealthNames = map[IPHealth]string{
+	IPHealthy:  "healthy",
+	IPEjected:  "ejected",
+	IPDraining: "draining",
+}
+
+func (h IPHealth) String() string {
+	if name, ok := ipHealthNames[h]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// IPStateView is a snapshot of the state T keeps for one of a host's IPs.
+type IPStateView struct {
+	Host   string
+	IP     net.IP
+	Health IPHealth
+	// InFlight is the number of requests to IP whose response bodies haven't been finished,
+	// and OpenConns the number of open connections to it.
+	InFlight, OpenConns int64
+	// Latency is a moving average of the durations of attempts sent to IP, until their
+	// response headers were received, or zero if none have been.
+	Latency time.Duration
+}
+
+// IPStates returns the state of each IP t knows for each host, ordered by host and IP, for
+// operational tooling: the IPs cached for the host, ejected, or draining. Each view is a
+// consistent snapshot of its IP's state.
+func (t *T) IPStates() []IPStateView {
+	var cached map[string][]net.IP
+	if t.ipCache == nil {
+		cached = t.hostIPs.snapshot()
+	} else {
+		cached = map[string][]net.IP{}
+	}
+	t.hostsMu.Lock()
+	hosts := make(map[string]*hostState, len(t.hosts))
+	for host, s := range t.hosts {
+		hosts[host] = s
+		if t.ipCache != nil {
+			cached[host] = t.ipCache.Get(host)
+		}
+	}
+	t.hostsMu.Unlock()
+	for host := range cached {
+		if _, ok := hosts[host]; !ok {
+			hosts[host] = nil
+		}
+	}
+
+	var views []IPStateView
+	for host, s := range hosts {
+		byIP := map[string]*IPStateView{}
+		for _, ip := range cached[host] {
+			byIP[string(ip.To16())] = &IPStateView{Host: host, IP: ip}
+		}
+		if s == nil {
+			for _, v := range byIP {
+				views = append(views, *v)
+			}
+			continue
+		}
+		for _, ip := range s.ejected() {
+			byIP[string(ip.To16())] = &IPStateView{Host: host, IP: ip, Health: IPEjected}
+		}
+		s.ipsMu.Lock()
+		for key, is := range s.ips {
+			v, ok := byIP[key]
+			inFlight := atomic.LoadInt64(&is.inFlight)
+			if !ok {
+				if inFlight == 0 {
+					continue // Forgotten.
+				}
+				v = &IPStateView{Host: host, IP: net.IP(key), Health: IPDraining}
+				byIP[key] = v
+			}
+			v.InFlight = inFlight
+			v.OpenConns = atomic.LoadInt64(&is.openConns)
+			is.mu.Lock()
+			v.Latency = is.latency
+			is.mu.Unlock()
+		}
+		s.ipsMu.Unlock()
+		for _, v := range byIP {
+			views = append(views, *v)
+		}
+	}
+	sort.Slice(views, func(i, j int) bool {
+		if views[i].Host != views[j].Host {
+			return views[i].Host < views[j].Host
+		}
+		return bytes.Compare(views[i].IP.To16(), views[j].IP.To16()) < 0
+	})
+	return views
+}
+
+// ResetIP clears the state t has learned about host's ip, for operational tooling: its
+// balancing scores (see WithAdaptiveWeights, WithBandwidthBalancing, and
+// WithSuccessRateBalancing) and latency are forgotten, and, if it's ejected, it's made a
+// candidate for requests again. Counts of in-flight requests and connections aren't reset.
+func (t *T) ResetIP(host string, ip net.IP) {
+	s := t.lookupHost(host)
+	if s == nil {
+		return
+	}
+	s.probeMu.Lock()
+	s.updateProbes(func(probes map[string]probeResult) {
+		if r, ok := probes[string(ip.To16())]; ok {
+			r.reachable = true
+			probes[string(ip.To16())] = r
+		}
+	})
+	s.probeMu.Unlock()
+	if is := s.lookupIP(ip); is != nil {
+		is.mu.Lock()
+		is.ipScores, is.snapshot, is.snapshotAt = ipScores{}, ipScores{}, time.Time{}
+		is.outcomes, is.nextOutcome = nil, 0
+		is.latency = 0
+		is.mu.Unlock()
+	}
+}
+
+// ejected returns the IPs that failed s's reachability probes.
+func (s *hostState) ejected() []net.IP {
+	var ejected []net.IP
+	for _, r := range s.loadProbes() {
+		if !r.reachable {
+			ejected = append(ejected, r.ip)
+		}
+	}
+	return ejected
+}
+
+// recordLatency adds a measurement, d, to the moving average latency of host's ip.
+func (t *T) recordLatency(host string, ip net.IP, d time.Duration) {
+	is := t.host(host).ip(ip)
+	is.mu.Lock()
+	if is.latency == 0 {
+		is.latency = d
+	} else {
+		is.latency += time.Duration(latencyWeight * float64(d-is.latency))
+	}
+	is.mu.Unlock()
+}
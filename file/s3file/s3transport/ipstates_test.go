@@ -0,0 +1,96 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPStates(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	// 10.0.0.2 refuses connections until it recovers.
+	var recovered int32
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if host, _, _ := net.SplitHostPort(addr); host == "10.0.0.2" && atomic.LoadInt32(&recovered) == 0 {
+				return nil, errors.New("connection refused")
+			}
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := newTestT(factory, testIPs(1, 2, 3), WithReachabilityProbe(time.Second))
+	defer rt.Close()
+	assert.Empty(t, rt.IPStates())
+
+	for i := 0; i < 10; i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	states := rt.IPStates()
+	require.Len(t, states, 3)
+	for i, want := range []IPHealth{IPHealthy, IPEjected, IPHealthy} {
+		assert.Equal(t, "s3.example.com", states[i].Host)
+		assert.Equal(t, testIPs(byte(i + 1))[0].String(), states[i].IP.String())
+		assert.Equal(t, want, states[i].Health, "%s", states[i].IP)
+		assert.Zero(t, states[i].InFlight)
+	}
+	assert.True(t, states[0].Latency > 0 || states[2].Latency > 0)
+	assert.Zero(t, states[1].Latency, "the ejected ip wasn't sent requests")
+
+	atomic.StoreInt32(&recovered, 1)
+	rt.ResetIP("s3.example.com", testIPs(2)[0])
+	for _, s := range rt.IPStates() {
+		assert.NotEqual(t, IPEjected, s.Health, "%s", s.IP)
+	}
+	for i := 0; i < 100 && !containsString(srv.Dialed(), "10.0.0.2"); i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+		rt.CloseIdleConnections()
+	}
+	assert.Contains(t, srv.Dialed(), "10.0.0.2", "the reset ip is a candidate again")
+	states = rt.IPStates()
+	require.Len(t, states, 3)
+	assert.Equal(t, IPHealthy, states[1].Health)
+}
+
+func TestIPStatesDraining(t *testing.T) {
+	release := make(chan struct{})
+	srv := newTestServer(blockingBodyHandler(release))
+	defer srv.Close()
+	defer close(release)
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	// The IP expires from the cache while its response is being read.
+	rt.hostIPs.expireOnce(time.Now().Add(2 * expireAfter))
+
+	states := rt.IPStates()
+	require.Len(t, states, 1)
+	assert.Equal(t, IPDraining, states[0].Health)
+	assert.Equal(t, int64(1), states[0].InFlight)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, candidate := range ss {
+		if candidate == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package s3transport
+
+import (
+	"net"
+	"time"
+)
+
+// Names of the metrics reported to a MetricsCollector.
+const (
+	// MetricConnWait is the time, in seconds, a request waited to acquire a connection.
+	// It's near zero when an idle pooled connection was available.
+	MetricConnWait = "s3transport_conn_wait_seconds"
+	// MetricDNSCacheHit is 1 for a request whose host's IPs were served from the DNS cache
+	// and 0 for one that resolved them afresh, so its mean is the cache hit ratio.
+	MetricDNSCacheHit = "s3transport_dns_cache_hit"
+	// MetricOpenConns is the number of open connections to a request's IP when the request
+	// got its connection. See also ConnStats.
+	MetricOpenConns = "s3transport_open_conns"
+	// MetricServerNameMismatch is 1 for each TLS handshake that failed because an IP's
+	// certificate didn't match the request's server name.
+	MetricServerNameMismatch = "s3transport_server_name_mismatch"
+	// MetricEvictedHostAge is the time, in seconds, since a host's IPs were first cached, for
+	// each host whose IPs all expire, and MetricEvictedHostIdle the time since the host was
+	// last used. Hosts that are evicted long after their last use suggest the cache's lifetime
+	// is too long; hosts evicted soon after frequent use, that it's too short.
+	MetricEvictedHostAge  = "s3transport_evicted_host_age_seconds"
+	MetricEvictedHostIdle = "s3transport_evicted_host_idle_seconds"
+)
+
+// Metric is a single observation reported to a MetricsCollector.
+type Metric struct {
+	// Name is one of the Metric* constants.
+	Name string
+	// Transport is the name of the T reporting the metric. See WithName.
+	Transport string
+	// Host is the request's original (not IP-rewritten) host.
+	Host string
+	// IP is the request's IP, for per-IP metrics, or empty.
+	IP string
+	// Value is the observed value, in the unit given by the metric's name.
+	Value float64
+}
+
+// MetricsCollector receives metrics from T. It's called synchronously on request paths, so
+// implementations should be fast. They must be safe for concurrent use.
+type MetricsCollector interface {
+	Observe(Metric)
+}
+
+// WithMetrics makes T report metrics to c.
+func WithMetrics(c MetricsCollector) Option {
+	return func(t *T) { t.metrics = c }
+}
+
+func (t *T) observe(name, host string, value float64) {
+	if t.metrics != nil {
+		t.metrics.Observe(Metric{Name: name, Transport: t.name, Host: host, Value: value})
+	}
+}
+
+func (t *T) observeIP(name, host string, ip net.IP, value float64) {
+	if t.metrics != nil {
+		t.metrics.Observe(Metric{Name: name, Transport: t.name, Host: host, IP: ip.String(), Value: value})
+	}
+}
+
+// observeExpiry reports the age and idle time of host when its cached IPs expired.
+func (t *T) observeExpiry(host string, age, idle time.Duration) {
+	t.observe(MetricEvictedHostAge, host, age.Seconds())
+	t.observe(MetricEvictedHostIdle, host, idle.Seconds())
+}
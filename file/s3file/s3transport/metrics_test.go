@@ -0,0 +1,103 @@
+package s3transport
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingCollector struct {
+	mu      sync.Mutex
+	metrics []Metric
+}
+
+func (c *recordingCollector) Observe(m Metric) {
+	c.mu.Lock()
+	c.metrics = append(c.metrics, m)
+	c.mu.Unlock()
+}
+
+// Named returns the recorded metrics with the given name.
+func (c *recordingCollector) Named(name string) (ret []Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.metrics {
+		if m.Name == name {
+			ret = append(ret, m)
+		}
+	}
+	return
+}
+
+func TestMetricConnWait(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+	}))
+	defer srv.Close()
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		transport.MaxConnsPerHost = 1
+		return transport
+	}
+	var collector recordingCollector
+	rt := newTestT(factory, testIPs(1), WithMetrics(&collector))
+	defer rt.Close()
+
+	const n = 4
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := get(context.Background(), rt, "http://s3.example.com/")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	waits := collector.Named(MetricConnWait)
+	require.Len(t, waits, n)
+	var maxWait float64
+	for _, m := range waits {
+		assert.Equal(t, "s3.example.com", m.Host)
+		if m.Value > maxWait {
+			maxWait = m.Value
+		}
+	}
+	assert.GreaterOrEqual(t, maxWait, delay.Seconds(), "some requests should wait for the single connection")
+}
+
+func TestMetricEvictedHostAge(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var collector recordingCollector
+	rt := newTestT(srv.factory, testIPs(1), WithMetrics(&collector))
+	defer rt.Close()
+	start := time.Unix(1600000000, 0)
+	now := start
+	rt.hostIPs.now = func() time.Time { return now }
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	now = now.Add(10 * time.Minute)
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+
+	rt.hostIPs.expireOnce(start.Add(expireAfter)) // Not yet expired.
+	assert.Empty(t, collector.Named(MetricEvictedHostAge))
+	evictAt := now.Add(expireAfter + time.Second)
+	rt.hostIPs.expireOnce(evictAt)
+	ages, idles := collector.Named(MetricEvictedHostAge), collector.Named(MetricEvictedHostIdle)
+	require.Len(t, ages, 1)
+	require.Len(t, idles, 1)
+	assert.Equal(t, "s3.example.com", ages[0].Host)
+	// The second request refreshed the IP, so it lived for the TTL after that.
+	assert.Equal(t, (10*time.Minute + expireAfter + time.Second).Seconds(), ages[0].Value)
+	assert.Equal(t, (expireAfter + time.Second).Seconds(), idles[0].Value)
+}
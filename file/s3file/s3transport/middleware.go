@@ -0,0 +1,26 @@
+package s3transport
+
+import "net/http"
+
+// WithMiddleware adds mw to the chain of middleware around T's routing, for example to
+// refresh credentials, alter requests, or validate responses. Middleware see the caller's
+// request, before its host is resolved and replaced by an IP, and the response T finally
+// returns (after any retries). Middleware added by earlier options wrap those added by later
+// ones, so the first sees requests first and responses last.
+func WithMiddleware(mw func(next http.RoundTripper) http.RoundTripper) Option {
+	return func(t *T) { t.middleware = append(t.middleware, mw) }
+}
+
+// chain returns core wrapped in t's middleware.
+func (t *T) chain(core http.RoundTripper) http.RoundTripper {
+	rt := core
+	for i := len(t.middleware) - 1; i >= 0; i-- {
+		rt = t.middleware[i](rt)
+	}
+	return rt
+}
+
+// roundTripperFunc adapts a func to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
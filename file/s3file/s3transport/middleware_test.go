@@ -0,0 +1,50 @@
+package s3transport
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	var gotHeader []string
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header["X-Middleware"]
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	var (
+		seenHosts  []string
+		seenStatus int
+	)
+	tag := func(name string) func(http.RoundTripper) http.RoundTripper {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				seenHosts = append(seenHosts, req.URL.Host)
+				req = req.Clone(req.Context())
+				req.Header.Add("X-Middleware", name)
+				resp, err := next.RoundTrip(req)
+				if err == nil && name == "outer" {
+					seenStatus = resp.StatusCode
+				}
+				return resp, err
+			})
+		}
+	}
+	rt := newTestT(srv.factory, testIPs(1), WithMiddleware(tag("outer")), WithMiddleware(tag("inner")))
+	defer rt.Close()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, []string{"outer", "inner"}, gotHeader)
+	assert.Equal(t, []string{"s3.example.com", "s3.example.com"}, seenHosts,
+		"middleware sees the original host")
+	assert.Equal(t, http.StatusAccepted, seenStatus)
+	assert.Empty(t, req.Header, "the caller's request is unmodified")
+}
@@ -0,0 +1,61 @@
+package s3transport
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type capturingOutputter struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (o *capturingOutputter) Level() log.Level { return log.Debug }
+
+func (o *capturingOutputter) Output(_ int, _ log.Level, s string) error {
+	o.mu.Lock()
+	o.lines = append(o.lines, s)
+	o.mu.Unlock()
+	return nil
+}
+
+func TestWithName(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var collector recordingCollector
+	rt := newTestT(srv.factory, testIPs(1), WithName("us-west-2"), WithMetrics(&collector))
+	defer rt.Close()
+
+	var h History
+	_, err := get(RecordAttempts(context.Background(), &h), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+
+	var out capturingOutputter
+	old := log.SetOutputter(&out)
+	rt.hostIPs.logOnce(time.Now())
+	log.SetOutputter(old)
+
+	require.Len(t, out.lines, 1)
+	assert.Contains(t, out.lines[0], "name:us-west-2 ")
+	waits := collector.Named(MetricConnWait)
+	require.NotEmpty(t, waits)
+	assert.Equal(t, "us-west-2", waits[0].Transport)
+	require.Len(t, h.Attempts(), 1)
+	assert.Equal(t, "us-west-2", h.Attempts()[0].Transport)
+
+	unnamed := New(http.DefaultTransport.(*http.Transport).Clone)
+	defer unnamed.Close()
+	out = capturingOutputter{}
+	old = log.SetOutputter(&out)
+	unnamed.hostIPs.logOnce(time.Now())
+	log.SetOutputter(old)
+	require.Len(t, out.lines, 1)
+	assert.NotContains(t, out.lines[0], "name:")
+}
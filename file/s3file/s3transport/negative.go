@@ -0,0 +1,78 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// WithNegativeCacheTTL makes T remember, for d, that resolving a host failed, and fail
+// requests for the host with the same error during that time, without querying the resolver
+// again. This protects the resolver from repeated lookups of a bad host, or during an outage.
+// A successful lookup (for example, by UseResolver) forgets the failure. WithStaleWhileError
+// still applies to cached failures.
+func WithNegativeCacheTTL(d time.Duration) Option {
+	return func(t *T) { t.negativeTTL = d }
+}
+
+// negativeCache remembers failed lookups. See WithNegativeCacheTTL.
+type negativeCache struct {
+	mu sync.Mutex
+	// entries is host -> its failure.
+	entries map[string]negativeEntry
+}
+
+type negativeEntry struct {
+	err error
+	// until is when the entry expires.
+	until time.Time
+}
+
+// lookupIPCached is t.resolver.lookupIPCached, with failures cached for t.negativeTTL.
+func (t *T) lookupIPCached(ctx context.Context, host string) (_ []net.IP, cached bool, _ error) {
+	if t.negativeTTL <= 0 {
+		return t.resolver.lookupIPCached(ctx, host)
+	}
+	now := t.resolver.now()
+	c := &t.negative
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && now.Before(entry.until) {
+		return nil, false, entry.err
+	}
+	ips, cached, err := t.resolver.lookupIPCached(ctx, host)
+	if err != nil && ctx.Err() != nil {
+		return nil, false, err // The caller gave up; the host may be fine.
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		if c.entries == nil {
+			c.entries = map[string]negativeEntry{}
+		}
+		c.entries[host] = negativeEntry{err, now.Add(t.negativeTTL)}
+	} else {
+		delete(c.entries, host)
+	}
+	return ips, cached, err
+}
+
+// forgetFailure forgets any cached failure to resolve host.
+func (t *T) forgetFailure(host string) {
+	t.negative.mu.Lock()
+	delete(t.negative.entries, host)
+	t.negative.mu.Unlock()
+}
+
+// prune forgets the failures that expired before now.
+func (c *negativeCache) prune(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for host, entry := range c.entries {
+		if !now.Before(entry.until) {
+			delete(c.entries, host)
+		}
+	}
+}
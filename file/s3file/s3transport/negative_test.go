@@ -0,0 +1,65 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegativeCacheTTL(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := New(srv.factory, WithNegativeCacheTTL(time.Second))
+	defer rt.Close()
+	var (
+		now     = time.Now()
+		lookups int
+	)
+	rt.resolver = newResolver(func(string) ([]net.IP, error) {
+		lookups++
+		return nil, errors.New("no such host")
+	}, func() time.Time { return now })
+
+	for i := 0; i < 5; i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		assert.EqualError(t, err, "s3transport: lookup ip: no such host")
+	}
+	assert.Equal(t, 1, lookups, "the failure is cached")
+
+	now = now.Add(time.Second)
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	assert.Error(t, err)
+	assert.Equal(t, 2, lookups, "the failure expired")
+
+	// A successful lookup forgets the failure.
+	working := ResolverFunc(func(context.Context, string) ([]net.IP, error) { return testIPs(1), nil })
+	_, err = get(UseResolver(context.Background(), working), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	assert.Error(t, err)
+	assert.Equal(t, 3, lookups)
+}
+
+func TestNegativeCachePruned(t *testing.T) {
+	rt := New(nil, WithNegativeCacheTTL(time.Second))
+	defer rt.Close()
+	now := time.Now()
+	rt.resolver = newResolver(func(string) ([]net.IP, error) {
+		return nil, errors.New("no such host")
+	}, func() time.Time { return now })
+
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		_, err := get(context.Background(), rt, "http://"+host+"/")
+		assert.Error(t, err)
+	}
+	rt.pruneState()
+	assert.Len(t, rt.negative.entries, 2, "unexpired failures are kept")
+	now = now.Add(time.Second)
+	rt.pruneState()
+	assert.Empty(t, rt.negative.entries, "hosts that aren't looked up again are forgotten")
+}
@@ -0,0 +1,109 @@
+package s3transport
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Option configures a T. Options are applied by New in the order they're given.
+type Option func(*T)
+
+// WithStrictExclusion makes RoundTrip return ErrAllIPsExcluded when the IPs excluded by
+// ExcludeIPs cover every candidate for the request's host. By default, such an exclusion is
+// ignored and the request is balanced over all candidates.
+func WithStrictExclusion() Option {
+	return func(t *T) { t.strictExclusion = true }
+}
+
+// WithECHConfigList configures Encrypted Client Hello on the per-host TLS configs, using the
+// given (serialized ECHConfigList) bytes, as for tls.Config.EncryptedClientHelloConfigList.
+// ServerName is still pinned to the request's host for certificate verification. Building
+// with Go versions that lack ECH support makes RoundTrip fail rather than connect without it.
+func WithECHConfigList(list []byte) Option {
+	return func(t *T) { t.echConfigList = list }
+}
+
+// WithMaxHosts bounds the number of hosts whose IPs (and per-host transports) T retains to n.
+// When a new host would exceed n, the least recently used host is evicted and its idle
+// connections closed. n <= 0 means unbounded, the default.
+func WithMaxHosts(n int) Option {
+	return func(t *T) { t.maxHosts = n }
+}
+
+// WithMaxIPsPerHost bounds the number of IPs T remembers for each host to n, so that a host
+// whose DNS rotates through many IPs doesn't spread connections thin. When a host would
+// exceed n, the IPs seen least recently are forgotten. n <= 0 means unbounded, the default.
+func WithMaxIPsPerHost(n int) Option {
+	return func(t *T) { t.maxIPsPerHost = n }
+}
+
+// WithPreferredSubnets makes T send requests only to IPs within subnets (for example, the
+// caller's own subnet or availability zone, to avoid cross-AZ transfer charges) when any of a
+// host's candidate IPs are within them. Otherwise, all candidates are used.
+func WithPreferredSubnets(subnets []net.IPNet) Option {
+	return func(t *T) { t.preferredSubnets = subnets }
+}
+
+// WithResponseHeaderTimeout bounds the time to wait for a server's response headers after
+// fully writing the request (see http.Transport.ResponseHeaderTimeout), so requests to a
+// frontend that accepts connections but stalls fail fast. It overrides the factory's setting.
+func WithResponseHeaderTimeout(d time.Duration) Option {
+	return func(t *T) { t.responseHeaderTimeout = d }
+}
+
+// WithDisableKeepAlives makes T's transports use a new connection for every request (see
+// http.Transport.DisableKeepAlives), so that each request exercises dialing, TLS, and IP
+// selection, rather than reusing a pooled connection to a previously chosen IP. It's for
+// load testing and debugging. It overrides the factory's setting.
+func WithDisableKeepAlives() Option {
+	return func(t *T) { t.disableKeepAlives = true }
+}
+
+// WithDialRateLimit limits the rate at which T opens new connections, across all hosts, to
+// perSec, for gateways that protect themselves against bursts of connections. Dials wait
+// their turn, but requests still fail when their contexts expire. Requests on pooled
+// connections aren't limited. If perSec isn't positive, it removes the limit, as by default,
+// when dials aren't limited.
+func WithDialRateLimit(perSec int) Option {
+	return func(t *T) {
+		t.dialLimiter = nil
+		if perSec > 0 {
+			t.dialLimiter = rate.NewLimiter(rate.Limit(perSec), 1)
+		}
+	}
+}
+
+// WithDialNetwork makes T dial connections with network, which must be "tcp4", "tcp6", or
+// "tcp", rather than the network its transports ask for, to pin the socket's address family
+// (for example, to avoid dual-stack fallback delays). It applies to reachability probes, too.
+// Requests fail if network is something else.
+func WithDialNetwork(network string) Option {
+	return func(t *T) { t.dialNetwork = network }
+}
+
+// WithStaleConnRetry makes T resend a request, once, when it fails on a connection reused
+// from the pool (for example, one silently dropped by a NAT). The resend goes to a different
+// IP, if the host has one, since a dead pooled connection often means a dead peer. This
+// complements http.Transport's own retry, which reuses the same address. Only requests whose
+// body can be replayed are resent.
+func WithStaleConnRetry() Option {
+	return func(t *T) { t.staleConnRetry = true }
+}
+
+// WithScoreRefreshInterval makes the balancers that choose IPs by measured scores
+// (WithBandwidthBalancing, WithAdaptiveWeights, and WithSuccessRateBalancing) use a snapshot
+// of each IP's scores that's refreshed at most once every d, rather than scores that change
+// with every request. This gives a stabler view that changes at a controlled cadence. By
+// default, balancers use current scores.
+func WithScoreRefreshInterval(d time.Duration) Option {
+	return func(t *T) { t.scoreRefresh = d }
+}
+
+// WithName labels T's log lines, metrics, and attempt records with name, to tell apart the
+// transports of a process that has several (for example, for different regions). The default
+// is empty.
+func WithName(name string) Option {
+	return func(t *T) { t.name = name }
+}
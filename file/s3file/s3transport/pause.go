@@ -0,0 +1,36 @@
+package s3transport
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHostPaused is returned (wrapped) by RoundTrip for requests to a host paused with Pause.
+var ErrHostPaused = errors.New("s3transport: host is paused")
+
+// Pause makes RoundTrip fail new requests to host with ErrHostPaused until Resume(host) is
+// called: both requests for host, before they're routed (see WithMethodRouting and
+// WithRegionalEndpoint), and requests routed or redirected to it. This is useful for failover
+// drills. In-flight requests are unaffected.
+func (t *T) Pause(host string) {
+	t.pausedMu.Lock()
+	t.paused[host] = true
+	t.pausedMu.Unlock()
+}
+
+// Resume undoes Pause(host). It's a no-op if host isn't paused.
+func (t *T) Resume(host string) {
+	t.pausedMu.Lock()
+	delete(t.paused, host)
+	t.pausedMu.Unlock()
+}
+
+func (t *T) checkPaused(host string) error {
+	t.pausedMu.RLock()
+	paused := t.paused[host]
+	t.pausedMu.RUnlock()
+	if paused {
+		return fmt.Errorf("%w: %s", ErrHostPaused, host)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPauseResume(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+
+	rt.Pause("s3.example.com")
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	assert.True(t, errors.Is(err, ErrHostPaused), "%v", err)
+	_, err = get(context.Background(), rt, "http://s3-other.example.com/")
+	assert.NoError(t, err, "other hosts are unaffected")
+
+	rt.Resume("s3.example.com")
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	assert.NoError(t, err)
+	rt.Resume("s3.example.com") // No-op.
+}
+
+func TestPauseRouted(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1),
+		WithMethodRouting(map[string]string{"GET": "replica.example.com"}))
+	defer rt.Close()
+
+	rt.Pause("s3.example.com")
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	assert.True(t, errors.Is(err, ErrHostPaused), "the original host is checked: %v", err)
+
+	rt.Resume("s3.example.com")
+	rt.Pause("replica.example.com")
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	assert.True(t, errors.Is(err, ErrHostPaused), "so is the routed one: %v", err)
+}
@@ -0,0 +1,112 @@
+package s3transport
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/grailbio/base/log"
+)
+
+// CacheEntry is one of the IPs T has learned for a host, as exported by ExportCache.
+type CacheEntry struct {
+	Host string `json:"host"`
+	IP   net.IP `json:"ip"`
+	// Expires is when IP stops being a candidate for Host's requests, unless Host resolves to
+	// it again.
+	Expires time.Time `json:"expires"`
+}
+
+// ExportCache returns the unexpired IPs t has learned for each host, for ImportCache. IPs
+// stored in an IPCache given to WithIPCache aren't exported.
+func (t *T) ExportCache() []CacheEntry {
+	if t.ipCache != nil {
+		return nil
+	}
+	return t.hostIPs.export()
+}
+
+// ImportCache adds entries, for example, exported by another process's T, to t's cache, for
+// the rest of their lifetimes; expired entries are ignored. If t has its own DNS cache (with
+// WithPersistentCacheFile, WithResolverChain, or WithRespectDNSTTL), requests to the entries'
+// hosts also use the imported IPs, for a few seconds, without waiting to resolve them, unless
+// t has already resolved them. Otherwise, the imported IPs are merged with resolved ones.
+func (t *T) ImportCache(entries []CacheEntry) {
+	now := t.hostIPs.now()
+	byHost := map[string][]net.IP{}
+	for _, e := range entries {
+		ttl := e.Expires.Sub(now)
+		if e.Host == "" || e.IP == nil || ttl <= 0 {
+			continue
+		}
+		if t.ipCache != nil {
+			t.ipCache.Put(e.Host, []net.IP{e.IP}, ttl)
+		} else {
+			t.hostIPs.Put(e.Host, []net.IP{e.IP}, ttl)
+		}
+		byHost[e.Host] = append(byHost[e.Host], e.IP)
+	}
+	if t.resolver == defaultResolver {
+		return // It's shared with other Ts.
+	}
+	for host, ips := range byHost {
+		t.resolver.seed(host, ips)
+	}
+}
+
+// WithPersistentCacheFile makes New import the learned IPs saved in the file at path (see
+// ImportCache), and Close (and Shutdown) save t's unexpired ones there (see ExportCache), so
+// that short-lived processes that run repeatedly don't resolve hosts cold each time. IPs are
+// retained, as in memory, for an hour after they were last resolved. Files that are missing,
+// corrupt, or whose entries have expired are ignored; problems reading or writing the file
+// are logged.
+func WithPersistentCacheFile(path string) Option {
+	return func(t *T) { t.cacheFile = path }
+}
+
+// loadCacheFile imports the entries saved in t.cacheFile.
+func (t *T) loadCacheFile() {
+	data, err := ioutil.ReadFile(t.cacheFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("s3transport: reading cache file: %v", err)
+		return
+	}
+	var entries []CacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("s3transport: ignoring corrupt cache file %s: %v", t.cacheFile, err)
+		return
+	}
+	t.ImportCache(entries)
+}
+
+// saveCacheFile replaces t.cacheFile with t's unexpired entries. The file is replaced
+// atomically, so that concurrent processes don't see partial writes.
+func (t *T) saveCacheFile() {
+	data, err := json.Marshal(t.ExportCache())
+	if err != nil {
+		log.Printf("s3transport: encoding cache file: %v", err)
+		return
+	}
+	f, err := ioutil.TempFile(filepath.Dir(t.cacheFile), filepath.Base(t.cacheFile)+".tmp")
+	if err != nil {
+		log.Printf("s3transport: writing cache file: %v", err)
+		return
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), t.cacheFile)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		log.Printf("s3transport: writing cache file: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package s3transport
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentCacheFile(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "cache.json")
+
+	r := &fixedTTLResolver{}
+	rt := New(srv.factory, WithResolverChain(r), WithPersistentCacheFile(path))
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	entries := rt.ExportCache()
+	rt.Close()
+	require.Equal(t, 1, r.lookups)
+	require.Len(t, entries, 1)
+	assert.WithinDuration(t, time.Now().Add(expireAfter), entries[0].Expires, time.Minute,
+		"ips are saved with their remaining lifetime, not the DNS cache's")
+
+	// The next process resolves the host to a different IP, but still uses the saved one.
+	r = &fixedTTLResolver{ips: testIPs(2)}
+	rt = New(srv.factory, WithResolverChain(r), WithPersistentCacheFile(path))
+	defer rt.Close()
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, 0, r.lookups, "the saved ips are used without resolving the host")
+	assert.Equal(t, []string{testIPs(1)[0].String()}, dedup(srv.Dialed()))
+
+	rt.resolver.now = func() time.Time { return time.Now().Add(time.Minute) }
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, 1, r.lookups)
+	assert.ElementsMatch(t, testIPs(1, 2), rt.hostIPs.Get("s3.example.com"),
+		"the saved ips remain candidates after resolving")
+}
+
+func TestPersistentCacheFileIgnored(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for name, contents := range map[string]string{
+		"corrupt": `[{"host": "s3.example.com", "ip": `,
+		"stale":   `[{"host": "s3.example.com", "ip": "10.0.0.9", "expires": "2020-01-01T00:00:00Z"}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+			r := &fixedTTLResolver{}
+			rt := New(srv.factory, WithResolverChain(r), WithPersistentCacheFile(path))
+			defer rt.Close()
+			_, err := get(context.Background(), rt, "http://s3.example.com/")
+			require.NoError(t, err)
+			assert.Equal(t, 1, r.lookups)
+		})
+	}
+	assert.Equal(t, []string{testIPs(1)[0].String()}, dedup(srv.Dialed()))
+}
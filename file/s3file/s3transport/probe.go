@@ -0,0 +1,143 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WithReachabilityProbe makes T check that each IP a host resolves to accepts a TCP
+// connection within timeout, when the IP is first seen and then hourly, and remember only the
+// IPs that do. This avoids sending requests to IPs that resolve but are unreachable (for
+// example, stale split-horizon records). If none are reachable, all are remembered, so that
+// requests can still be attempted. Probes use the factory's dialer, taken once by New, and,
+// like requests, respect WithDialRateLimit, WithDialNetwork, and WithConnectProxy, but aren't
+// counted by ConnStats. By default, IPs aren't probed.
+func WithReachabilityProbe(timeout time.Duration) Option {
+	return func(t *T) { t.probeTimeout = timeout }
+}
+
+// probeResult is the outcome of probing one of a host's IPs.
+type probeResult struct {
+	ip        net.IP
+	reachable bool
+	at        time.Time
+}
+
+// reachable returns the ips, which key's host resolved to, that accept connections on the
+// port req is for, or all of ips if none do. Since S3 answers with varying subsets of its
+// IPs, each IP is probed only when it's first seen, and again once its result is forgotten
+// (see forgetProbes).
+func (t *T) reachable(req *http.Request, key hostKey, ips []net.IP) []net.IP {
+	s := t.host(key.host)
+	probes := s.loadProbes()
+	if unprobed(probes, ips) != nil {
+		s.probeMu.Lock()
+		if newIPs := unprobed(s.loadProbes(), ips); newIPs != nil {
+			now := t.hostIPs.now()
+			ok := t.probe(req, key, newIPs)
+			s.updateProbes(func(probes map[string]probeResult) {
+				for i, ip := range newIPs {
+					probes[string(ip.To16())] = probeResult{ip, ok[i], now}
+				}
+			})
+			for i, ip := range newIPs {
+				if !ok[i] {
+					t.emit(Event{Kind: EventEjected, Host: key.host, IP: ip})
+				}
+			}
+		}
+		probes = s.loadProbes()
+		s.probeMu.Unlock()
+	}
+	var reachable []net.IP
+	for _, ip := range ips {
+		if probes[string(ip.To16())].reachable {
+			reachable = append(reachable, ip)
+		}
+	}
+	if len(reachable) == 0 {
+		return ips
+	}
+	return reachable
+}
+
+// loadProbes returns s's probe results, which must not be modified.
+func (s *hostState) loadProbes() map[string]probeResult {
+	probes, _ := s.probes.Load().(map[string]probeResult)
+	return probes
+}
+
+// updateProbes replaces s's probe results with update's copy of them. s.probeMu must be held.
+func (s *hostState) updateProbes(update func(map[string]probeResult)) {
+	probes := s.loadProbes()
+	updated := make(map[string]probeResult, len(probes))
+	for k, r := range probes {
+		updated[k] = r
+	}
+	update(updated)
+	s.probes.Store(updated)
+}
+
+// forgetProbes forgets the results of s's probes made before threshold, so that their IPs are
+// probed again if they're resolved again.
+func (s *hostState) forgetProbes(threshold time.Time) {
+	s.probeMu.Lock()
+	defer s.probeMu.Unlock()
+	s.updateProbes(func(probes map[string]probeResult) {
+		for k, r := range probes {
+			if r.at.Before(threshold) {
+				delete(probes, k)
+			}
+		}
+	})
+}
+
+// unprobed returns the ips that have no result in probes.
+func unprobed(probes map[string]probeResult, ips []net.IP) (newIPs []net.IP) {
+	for _, ip := range ips {
+		if _, ok := probes[string(ip.To16())]; !ok {
+			newIPs = append(newIPs, ip)
+		}
+	}
+	return
+}
+
+// probe reports whether each of ips accepts connections on the port req is for.
+func (t *T) probe(req *http.Request, key hostKey, ips []net.IP) []bool {
+	port := req.URL.Port()
+	if port == "" {
+		port = "443"
+		if key.plaintext {
+			port = "80"
+		}
+	}
+	ok := make([]bool, len(ips))
+	var wg sync.WaitGroup
+	wg.Add(len(ips))
+	for i := range ips {
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(req.Context(), t.probeTimeout)
+			defer cancel()
+			addr := net.JoinHostPort(ips[i].String(), port)
+			var (
+				conn net.Conn
+				err  error
+			)
+			if t.connectProxy != "" {
+				conn, err = t.dialTunnel(ctx, t.probeDial, addr)
+			} else {
+				conn, err = t.probeDial(ctx, "tcp", addr)
+			}
+			if err == nil {
+				ok[i] = true
+				_ = conn.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return ok
+}
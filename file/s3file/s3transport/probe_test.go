@@ -0,0 +1,117 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// refusingFactory returns a factory like srv's whose transports can't connect to refused.
+func refusingFactory(srv *testServer, refused ...net.IP) func() *http.Transport {
+	return func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, _ := net.SplitHostPort(addr)
+			for _, ip := range refused {
+				if host == ip.String() {
+					return nil, errors.New("connection refused")
+				}
+			}
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+}
+
+func TestReachabilityProbe(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(refusingFactory(srv, testIPs(2)...), testIPs(1, 2, 3),
+		WithReachabilityProbe(time.Second))
+	defer rt.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	assert.ElementsMatch(t, testIPs(1, 3), rt.hostIPs.AddAndGet("s3.example.com", nil))
+}
+
+func TestReachabilityProbeNoneReachable(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(refusingFactory(srv, testIPs(1, 2)...), testIPs(1, 2),
+		WithReachabilityProbe(time.Second))
+	defer rt.Close()
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	assert.Error(t, err)
+	assert.ElementsMatch(t, testIPs(1, 2), rt.hostIPs.AddAndGet("s3.example.com", nil),
+		"all IPs are cached rather than none")
+}
+
+func TestReachabilityProbeNewIPsOnly(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var (
+		mu     sync.Mutex
+		probes []string
+	)
+	factory := func() *http.Transport {
+		transport := refusingFactory(srv, testIPs(2)...)()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			probes = append(probes, addr)
+			mu.Unlock()
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := New(factory, WithReachabilityProbe(time.Second))
+	defer rt.Close()
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	key := hostKey{host: "s3.example.com", plaintext: true}
+
+	assert.ElementsMatch(t, testIPs(1, 3), rt.reachable(req, key, testIPs(1, 2, 3)))
+	assert.ElementsMatch(t, testIPs(3, 1), rt.reachable(req, key, testIPs(3, 2, 1)),
+		"the order of the answer doesn't matter")
+	assert.ElementsMatch(t, testIPs(1, 4), rt.reachable(req, key, testIPs(2, 1, 4)))
+	assert.ElementsMatch(t, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80"}, probes,
+		"each IP is probed once")
+
+	// Once results are forgotten, IPs are probed again.
+	rt.lookupHost("s3.example.com").forgetProbes(time.Now().Add(time.Second))
+	assert.ElementsMatch(t, testIPs(1), rt.reachable(req, key, testIPs(1, 2)))
+	assert.Len(t, probes, 6)
+}
+
+func TestReachabilityProbeDialer(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var factoryCalls int32
+	factory := func() *http.Transport {
+		atomic.AddInt32(&factoryCalls, 1)
+		return srv.factory()
+	}
+	// Probes share the dial rate limit with requests: only one dial is allowed in time.
+	rt := New(factory, WithReachabilityProbe(100*time.Millisecond), WithDialRateLimit(1))
+	defer rt.Close()
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	key := hostKey{host: "s3.example.com", plaintext: true}
+
+	assert.Len(t, rt.reachable(req, key, testIPs(1, 2, 3)), 1)
+	rt.reachable(req, key, testIPs(4, 5))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&factoryCalls), "the dialer is captured once, by New")
+}
@@ -0,0 +1,127 @@
+package s3transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WithConnectProxy makes T reach servers through the HTTP proxy at addr ("host:port", or an
+// http:// or https:// URL), for networks where that's the only way out, like through a
+// bastion. For https:// requests, T asks the proxy to tunnel (with CONNECT) to the IP it
+// chose for the request, then verifies TLS against the request's original host, as it does
+// without a proxy. Plain http:// requests are forwarded by the proxy as usual. The factory's
+// dialer connects to the proxy; connections to it are counted (see ConnStats and WaitWarm)
+// for the IP they tunnel to, and plain http:// connections, which may carry requests for
+// any IP, aren't counted. Reachability probes (see WithReachabilityProbe) tunnel through the
+// proxy, too. It overrides the factory's Proxy.
+func WithConnectProxy(addr string) Option {
+	return func(t *T) { t.connectProxy = addr }
+}
+
+// configureProxy makes transport use t.connectProxy, if it's set.
+func (t *T) configureProxy(transport *http.Transport) error {
+	if t.connectProxy == "" {
+		return nil
+	}
+	u, err := t.proxyURL()
+	if err != nil {
+		return err
+	}
+	transport.Proxy = http.ProxyURL(u)
+	return nil
+}
+
+// proxyURL returns t.connectProxy's URL.
+func (t *T) proxyURL() (*url.URL, error) {
+	addr := t.connectProxy
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("s3transport: invalid connect proxy %q", t.connectProxy)
+	}
+	return u, nil
+}
+
+// dialTunnel connects to addr through t.connectProxy, with dial, as http.Transport does for
+// https:// requests.
+func (t *T) dialTunnel(ctx context.Context, dial dialFunc, addr string) (net.Conn, error) {
+	u, err := t.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := connectReq.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connectReq)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("s3transport: connect proxy: %s", resp.Status)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+type tunnelIPKey struct{}
+
+// withTunnelIP returns ctx, for req, addressed to ip, noting that dials for it connect to
+// t.connectProxy to tunnel to ip, if they do.
+func (t *T) withTunnelIP(ctx context.Context, req *http.Request, ip net.IP) context.Context {
+	if t.connectProxy == "" || req.URL.Scheme != "https" {
+		return ctx
+	}
+	return context.WithValue(ctx, tunnelIPKey{}, ip)
+}
+
+// tunnelIPFromContext returns the IP set by withTunnelIP, if any.
+func tunnelIPFromContext(ctx context.Context) net.IP {
+	ip, _ := ctx.Value(tunnelIPKey{}).(net.IP)
+	return ip
+}
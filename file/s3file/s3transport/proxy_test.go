@@ -0,0 +1,136 @@
+package s3transport
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectProxy is a CONNECT proxy stub that tunnels every request to an upstream server,
+// whatever its target, and records the targets.
+type connectProxy struct {
+	*httptest.Server
+	upstream string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newConnectProxy(upstream string) *connectProxy {
+	p := &connectProxy{upstream: upstream}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	return p
+}
+
+func (p *connectProxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	p.mu.Lock()
+	p.targets = append(p.targets, r.Host)
+	p.mu.Unlock()
+	upstream, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+	w.WriteHeader(http.StatusOK)
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	go func() { _, _ = io.Copy(upstream, buf) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func (p *connectProxy) Targets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func TestConnectProxy(t *testing.T) {
+	srv := httptest.NewTLSServer(okHandler())
+	defer srv.Close()
+	proxy := newConnectProxy(srv.Listener.Addr().String())
+	defer proxy.Close()
+	// TLS is verified, with srv's certificate, which is for *.example.com.
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	factory := func() *http.Transport {
+		return &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	}
+	rt := newTestT(factory, testIPs(1), WithConnectProxy(proxy.Listener.Addr().String()))
+	defer rt.Close()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet,
+		"https://s3.example.com/bucket/key", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotNil(t, resp.TLS)
+	assert.Equal(t, "s3.example.com", resp.TLS.ServerName)
+	assert.Equal(t, []string{"10.0.0.1:443"}, proxy.Targets())
+}
+
+func TestConnectProxyInvalid(t *testing.T) {
+	rt := newTestT(http.DefaultTransport.(*http.Transport).Clone, testIPs(1), WithConnectProxy("http://"))
+	defer rt.Close()
+	_, err := get(context.Background(), rt, "https://s3.example.com/")
+	assert.Error(t, err)
+}
+
+func TestConnectProxyWaitWarm(t *testing.T) {
+	srv := httptest.NewTLSServer(okHandler())
+	defer srv.Close()
+	proxy := newConnectProxy(srv.Listener.Addr().String())
+	defer proxy.Close()
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	factory := func() *http.Transport {
+		return &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, MaxIdleConnsPerHost: 4}
+	}
+	rt := newTestT(factory, testIPs(1), WithConnectProxy(proxy.Listener.Addr().String()))
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, rt.WaitWarm(ctx, "s3.example.com", 3))
+	stats := rt.ConnStats("s3.example.com")
+	require.Len(t, stats, 1)
+	assert.True(t, stats[0].IP.Equal(testIPs(1)[0]), "tunnels are counted for their target")
+	assert.GreaterOrEqual(t, stats[0].OpenConns, int64(3))
+	assert.GreaterOrEqual(t, stats[0].Conns, uint64(3))
+}
+
+func TestConnectProxyReachabilityProbe(t *testing.T) {
+	srv := httptest.NewTLSServer(okHandler())
+	defer srv.Close()
+	proxy := newConnectProxy(srv.Listener.Addr().String())
+	defer proxy.Close()
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	factory := func() *http.Transport {
+		return &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	}
+	rt := newTestT(factory, testIPs(1, 2), WithConnectProxy(proxy.Listener.Addr().String()),
+		WithReachabilityProbe(time.Second))
+	defer rt.Close()
+
+	_, err := get(context.Background(), rt, "https://s3.example.com/")
+	require.NoError(t, err)
+	assert.Subset(t, proxy.Targets(), []string{"10.0.0.1:443", "10.0.0.2:443"},
+		"the IPs are probed through the proxy")
+	assert.ElementsMatch(t, testIPs(1, 2), rt.hostIPs.Get("s3.example.com"))
+}
@@ -0,0 +1,168 @@
+package s3transport
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRememberedRedirects bounds the number of hosts whose redirects are remembered.
+	maxRememberedRedirects = 1000
+	// redirectTTL is how long redirects are remembered.
+	redirectTTL = expireAfter
+)
+
+// redirect is a remembered redirect target.
+type redirect struct {
+	target  string
+	expires time.Time
+}
+
+// WithRegionRedirects makes T follow S3's redirects to another region's endpoint (for a bucket
+// in a different region than the request's endpoint), at most max times per request.
+// A redirect is a 301, 307, or 308 response, from one of S3's endpoints or a bucket's virtual
+// host under one, with an x-amz-bucket-region header; it's followed to the same bucket at
+// EndpointForRegion of that region. The Location header is ignored, so that requests, and
+// their credentials, are only ever sent to S3. Only requests with idempotent methods and
+// replayable bodies are redirected; others, and redirects that would revisit a host, are
+// returned to the caller. Permanent (301) redirects are remembered, for an hour, so that later
+// requests go to the right host first. As with WithRegionalEndpoint, the Host header is
+// rewritten, so signed requests won't be accepted by the new host unless they're signed for
+// it. By default, redirects are returned to the caller.
+func WithRegionRedirects(max int) Option {
+	return func(t *T) { t.maxRedirects = max }
+}
+
+// redirected returns req, rewritten to a remembered redirect target, if any. req itself is
+// not modified.
+func (t *T) redirected(req *http.Request) *http.Request {
+	if t.maxRedirects <= 0 {
+		return req
+	}
+	host := req.URL.Hostname()
+	t.redirectsMu.Lock()
+	r, ok := t.redirects[host]
+	if ok && !time.Now().Before(r.expires) {
+		delete(t.redirects, host)
+		ok = false
+	}
+	t.redirectsMu.Unlock()
+	if !ok {
+		return req
+	}
+	return withHostname(req, r.target)
+}
+
+// rememberRedirect records that host is permanently redirected to target.
+func (t *T) rememberRedirect(host, target string) {
+	now := time.Now()
+	t.redirectsMu.Lock()
+	defer t.redirectsMu.Unlock()
+	if _, ok := t.redirects[host]; !ok && len(t.redirects) >= maxRememberedRedirects {
+		for h, r := range t.redirects {
+			if !now.Before(r.expires) {
+				delete(t.redirects, h)
+			}
+		}
+		for h := range t.redirects {
+			if len(t.redirects) < maxRememberedRedirects {
+				break
+			}
+			delete(t.redirects, h)
+		}
+	}
+	t.redirects[host] = redirect{target, now.Add(redirectTTL)}
+}
+
+// followRedirects follows the region redirects of req's outcome, resp or err, as configured.
+func (t *T) followRedirects(req *http.Request, resp *http.Response, err error) (*http.Response, error) {
+	origin := req.URL.Hostname()
+	visited := map[string]bool{origin: true}
+	for i := 0; i < t.maxRedirects && err == nil; i++ {
+		target, ok := redirectTarget(req, resp)
+		if !ok || visited[target] || !isIdempotent(req.Method) || !isReplayable(req) {
+			break
+		}
+		visited[target] = true
+		next, rewindErr := rewound(req.Context(), req)
+		if rewindErr != nil {
+			break
+		}
+		if resp.StatusCode == http.StatusMovedPermanently {
+			t.rememberRedirect(origin, target)
+		}
+		discardResponse(resp)
+		req = withHostname(next, target)
+		resp, err = t.roundTrip(req, time.Now())
+	}
+	return resp, err
+}
+
+// redirectTarget returns the host that resp, a response to req, redirects to, if any.
+func redirectTarget(req *http.Request, resp *http.Response) (string, bool) {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return "", false
+	}
+	region := strings.ToLower(resp.Header.Get("X-Amz-Bucket-Region"))
+	if !isRegionName(region) {
+		return "", false
+	}
+	host := strings.ToLower(req.URL.Hostname())
+	bucket, ok := s3Bucket(host)
+	if !ok {
+		return "", false
+	}
+	target := bucket + EndpointForRegion(region)
+	return target, target != host
+}
+
+// s3Bucket returns the virtual-hosted bucket prefix of host, such as "bucket." (or "" for
+// path-style requests), if host is one of S3's endpoints or a bucket's virtual host under one.
+func s3Bucket(host string) (string, bool) {
+	var domain bool
+	for _, suffix := range []string{".amazonaws.com", ".amazonaws.com.cn", ".c2s.ic.gov", ".sc2s.sgov.gov"} {
+		if strings.HasSuffix(host, suffix) {
+			domain = true
+			break
+		}
+	}
+	if !domain {
+		return "", false
+	}
+	// Virtual-hosted buckets are named by the labels before the S3 endpoint's, "s3." or
+	// "s3-<region>.".
+	switch {
+	case strings.HasPrefix(host, "s3.") || strings.HasPrefix(host, "s3-"):
+		return "", true
+	case strings.Contains(host, ".s3."):
+		return host[:strings.Index(host, ".s3.")+1], true
+	case strings.Contains(host, ".s3-"):
+		return host[:strings.Index(host, ".s3-")+1], true
+	}
+	return "", false
+}
+
+// isRegionName reports whether region looks like an AWS region name, such as "us-west-2".
+func isRegionName(region string) bool {
+	if region == "" {
+		return false
+	}
+	for _, c := range region {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// isIdempotent reports whether requests with method may be sent more than once.
+func isIdempotent(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package s3transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegionRedirects(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		hosts []string
+	)
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Method+" "+r.Host)
+		mu.Unlock()
+		switch r.Host {
+		case "bucket.s3.amazonaws.com":
+			w.Header().Set("Location", "http://bucket.s3.us-west-2.amazonaws.com"+r.URL.Path)
+			w.Header().Set("X-Amz-Bucket-Region", "us-west-2")
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		case "s3.us-east-1.amazonaws.com":
+			w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+			w.WriteHeader(http.StatusMovedPermanently)
+		}
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithRegionRedirects(3))
+	defer rt.Close()
+
+	for _, url := range []string{
+		"http://bucket.s3.amazonaws.com/key",
+		"http://bucket.s3.amazonaws.com/key", // Temporary redirects aren't remembered.
+		"http://s3.us-east-1.amazonaws.com/bucket/key",
+	} {
+		resp, err := get(context.Background(), rt, url)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, url)
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://s3.us-east-1.amazonaws.com/bucket/key",
+		strings.NewReader("data"))
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "the redirect is remembered, even for POST")
+	assert.Equal(t, []string{
+		"GET bucket.s3.amazonaws.com",
+		"GET bucket.s3.us-west-2.amazonaws.com",
+		"GET bucket.s3.amazonaws.com",
+		"GET bucket.s3.us-west-2.amazonaws.com",
+		"GET s3.us-east-1.amazonaws.com",
+		"GET s3.eu-west-1.amazonaws.com",
+		"POST s3.eu-west-1.amazonaws.com",
+	}, hosts)
+}
+
+func TestRegionRedirectsOnlyToS3(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.Header().Set("Location", "http://evil.example.com/")
+		w.Header().Set("X-Amz-Bucket-Region", r.URL.Query().Get("region"))
+		w.WriteHeader(http.StatusMovedPermanently)
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithRegionRedirects(3))
+	defer rt.Close()
+
+	for _, url := range []string{
+		"http://bucket.s3.amazonaws.com/key",                          // No region.
+		"http://bucket.s3.amazonaws.com/key?region=evil.example.com/", // Not a region.
+		"http://bucket.example.com/key?region=us-west-2",              // Not S3.
+		"http://bucket.s3.example.com/key?region=us-west-2",           // Not S3 either.
+	} {
+		resp, err := get(context.Background(), rt, url)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode, url)
+	}
+	assert.Equal(t, 4, requests)
+	assert.Empty(t, rt.redirects)
+}
+
+func TestRememberedRedirectsBounded(t *testing.T) {
+	rt := New(nil, WithRegionRedirects(1))
+	defer rt.Close()
+	for i := 0; i < maxRememberedRedirects+10; i++ {
+		rt.rememberRedirect(fmt.Sprintf("b%d.s3.amazonaws.com", i), "s3.us-west-2.amazonaws.com")
+	}
+	assert.Len(t, rt.redirects, maxRememberedRedirects)
+
+	rt.rememberRedirect("bucket.s3.amazonaws.com", "bucket.s3.us-west-2.amazonaws.com")
+	req, err := http.NewRequest(http.MethodGet, "http://bucket.s3.amazonaws.com/key", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "bucket.s3.us-west-2.amazonaws.com", rt.redirected(req).URL.Host)
+	rt.redirects["bucket.s3.amazonaws.com"] = redirect{"bucket.s3.us-west-2.amazonaws.com", time.Now()}
+	assert.Equal(t, "bucket.s3.amazonaws.com", rt.redirected(req).URL.Host, "the redirect expired")
+	assert.NotContains(t, rt.redirects, "bucket.s3.amazonaws.com")
+}
+
+func TestRegionRedirectLoop(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		next := "us-east-1"
+		if r.Host == "s3.us-east-1.amazonaws.com" {
+			next = "eu-west-1"
+		}
+		w.Header().Set("X-Amz-Bucket-Region", next)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithRegionRedirects(10))
+	defer rt.Close()
+
+	resp, err := get(context.Background(), rt, "http://s3.us-east-1.amazonaws.com/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, "the loop is returned to the caller")
+	assert.Equal(t, 2, requests)
+}
+
+func TestRegionRedirectsMax(t *testing.T) {
+	var requests int
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("X-Amz-Bucket-Region", fmt.Sprintf("region-%d", requests))
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithRegionRedirects(2))
+	defer rt.Close()
+
+	resp, err := get(context.Background(), rt, "http://s3.us-east-1.amazonaws.com/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Equal(t, 3, requests)
+}
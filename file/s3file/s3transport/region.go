@@ -0,0 +1,82 @@
+package s3transport
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// genericEndpoint is S3's global, region-less endpoint.
+const genericEndpoint = "s3.amazonaws.com"
+
+// EndpointForRegion returns the hostname of S3's endpoint for region, such as
+// "s3.us-west-2.amazonaws.com", accounting for the China and ISO partitions. GovCloud regions
+// are in the standard domain. If region is empty it returns the global endpoint,
+// "s3.amazonaws.com".
+func EndpointForRegion(region string) string {
+	return endpointForRegion("s3.", region)
+}
+
+// DualStackEndpointForRegion is like EndpointForRegion but returns the endpoint that serves
+// both IPv4 and IPv6, such as "s3.dualstack.us-west-2.amazonaws.com".
+func DualStackEndpointForRegion(region string) string {
+	return endpointForRegion("s3.dualstack.", region)
+}
+
+func endpointForRegion(prefix, region string) string {
+	region = strings.ToLower(region)
+	if region == "" {
+		return prefix + "amazonaws.com"
+	}
+	suffix := ".amazonaws.com"
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		suffix = ".amazonaws.com.cn"
+	case strings.HasPrefix(region, "us-isob-"):
+		suffix = ".sc2s.sgov.gov"
+	case strings.HasPrefix(region, "us-iso-"):
+		suffix = ".c2s.ic.gov"
+	}
+	return prefix + region + suffix
+}
+
+// WithRegionalEndpoint makes T rewrite requests for S3's global endpoint, s3.amazonaws.com
+// or a bucket's virtual host under it, to endpoint (typically from EndpointForRegion) before
+// resolving them. Both the URL and the Host header are rewritten, so requests signed with
+// AWS Signature Version 4 must have been signed for endpoint. Other hosts are unaffected.
+func WithRegionalEndpoint(endpoint string) Option {
+	return func(t *T) { t.regionalEndpoint = endpoint }
+}
+
+// regionalized returns req, rewritten for WithRegionalEndpoint if necessary. req itself is
+// not modified.
+func (t *T) regionalized(req *http.Request) *http.Request {
+	if t.regionalEndpoint == "" {
+		return req
+	}
+	host := strings.ToLower(req.URL.Hostname())
+	var bucket string
+	switch {
+	case host == genericEndpoint:
+	case strings.HasSuffix(host, "."+genericEndpoint):
+		bucket = host[:len(host)-len(genericEndpoint)]
+	default:
+		return req
+	}
+	return withHostname(req, bucket+t.regionalEndpoint)
+}
+
+// withHostname returns a copy of req whose URL host, and Host header if it's set, have
+// hostname in place of the original's, keeping the port.
+func withHostname(req *http.Request, hostname string) *http.Request {
+	newHost := hostname
+	if port := req.URL.Port(); port != "" {
+		newHost = net.JoinHostPort(hostname, port)
+	}
+	clone := req.Clone(req.Context())
+	clone.URL.Host = newHost
+	if clone.Host != "" {
+		clone.Host = newHost
+	}
+	return clone
+}
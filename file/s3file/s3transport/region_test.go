@@ -0,0 +1,70 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointForRegion(t *testing.T) {
+	for _, c := range []struct{ region, endpoint, dualStack string }{
+		{"us-west-2", "s3.us-west-2.amazonaws.com", "s3.dualstack.us-west-2.amazonaws.com"},
+		{"us-east-1", "s3.us-east-1.amazonaws.com", "s3.dualstack.us-east-1.amazonaws.com"},
+		{"EU-Central-1", "s3.eu-central-1.amazonaws.com", "s3.dualstack.eu-central-1.amazonaws.com"},
+		{"us-gov-west-1", "s3.us-gov-west-1.amazonaws.com", "s3.dualstack.us-gov-west-1.amazonaws.com"},
+		{"cn-north-1", "s3.cn-north-1.amazonaws.com.cn", "s3.dualstack.cn-north-1.amazonaws.com.cn"},
+		{"cn-northwest-1", "s3.cn-northwest-1.amazonaws.com.cn", "s3.dualstack.cn-northwest-1.amazonaws.com.cn"},
+		{"us-iso-east-1", "s3.us-iso-east-1.c2s.ic.gov", "s3.dualstack.us-iso-east-1.c2s.ic.gov"},
+		{"us-isob-east-1", "s3.us-isob-east-1.sc2s.sgov.gov", "s3.dualstack.us-isob-east-1.sc2s.sgov.gov"},
+		{"", "s3.amazonaws.com", "s3.dualstack.amazonaws.com"},
+	} {
+		assert.Equal(t, c.endpoint, EndpointForRegion(c.region), c.region)
+		assert.Equal(t, c.dualStack, DualStackEndpointForRegion(c.region), c.region)
+	}
+}
+
+func TestRegionalEndpoint(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		looked, hosts []string
+	)
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Host)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, nil, WithRegionalEndpoint(EndpointForRegion("us-west-2")))
+	defer rt.Close()
+	rt.resolver = newResolver(func(host string) ([]net.IP, error) {
+		mu.Lock()
+		looked = append(looked, host)
+		mu.Unlock()
+		return testIPs(1), nil
+	}, time.Now)
+
+	for _, url := range []string{
+		"http://s3.amazonaws.com/bucket/key",
+		"http://bucket.s3.amazonaws.com:8080/key",
+		"http://s3.eu-west-1.amazonaws.com/bucket/key",
+	} {
+		_, err := get(context.Background(), rt, url)
+		require.NoError(t, err, url)
+	}
+	assert.Equal(t, []string{
+		"s3.us-west-2.amazonaws.com",
+		"bucket.s3.us-west-2.amazonaws.com",
+		"s3.eu-west-1.amazonaws.com",
+	}, looked)
+	assert.Equal(t, []string{
+		"s3.us-west-2.amazonaws.com",
+		"bucket.s3.us-west-2.amazonaws.com:8080",
+		"s3.eu-west-1.amazonaws.com",
+	}, hosts)
+}
@@ -0,0 +1,126 @@
+package s3transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/grailbio/base/sync/multierror"
+)
+
+// Resolver looks up the IPs of hosts. See WithResolverChain.
+type Resolver interface {
+	// LookupIP returns host's IPs. ctx is the context of the request being resolved.
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// ResolverFunc adapts a function to Resolver.
+type ResolverFunc func(ctx context.Context, host string) ([]net.IP, error)
+
+// LookupIP implements Resolver.
+func (f ResolverFunc) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return f(ctx, host)
+}
+
+// TTLResolver is a Resolver that also reports the TTLs of its results. See WithRespectDNSTTL.
+type TTLResolver interface {
+	Resolver
+	// LookupIPTTL is like LookupIP, and also returns how long the result may be cached, or
+	// zero if that's unknown.
+	LookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+}
+
+// SystemResolver resolves hosts with net.DefaultResolver, as T does by default.
+var SystemResolver Resolver = ResolverFunc(func(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+})
+
+// WithResolverChain makes T resolve hosts with resolvers, for example, the system resolver
+// followed by a DNS-over-HTTPS fallback. They're tried in order until one returns IPs without
+// an error; if none do, the lookup fails with all of their errors. Lookups stop early if the
+// request's context is done. Results are cached briefly, as the default resolver's are, or
+// for their TTLs with WithRespectDNSTTL.
+func WithResolverChain(resolvers ...Resolver) Option {
+	return func(t *T) {
+		t.resolver = newTTLResolver(resolverChain(resolvers).LookupIPTTL, time.Now)
+	}
+}
+
+// WithHostsMap makes T send requests for the hosts in hosts (URL hostnames, without ports) to
+// their listed IPs, instead of resolving them, like an /etc/hosts file; for example, to test
+// against a local S3-compatible server. The IPs don't expire, and aren't merged with other
+// IPs, probed by WithReachabilityProbe, or counted in Stats. Other hosts are resolved as usual.
+func WithHostsMap(hosts map[string][]net.IP) Option {
+	return func(t *T) {
+		t.hostsMap = make(map[string][]net.IP, len(hosts))
+		for host, ips := range hosts {
+			t.hostsMap[host] = append([]net.IP(nil), ips...)
+		}
+	}
+}
+
+type resolverKey struct{}
+
+// UseResolver returns a context that makes T resolve the host of a request using the context
+// with r, instead of its configured resolver. Such lookups bypass T's caches: their results
+// are neither cached nor merged with the host's previously seen IPs, and they aren't
+// counted in Stats or probed by WithReachabilityProbe. This is useful, for example, for
+// tests that target a specific DNS view.
+func UseResolver(ctx context.Context, r Resolver) context.Context {
+	return context.WithValue(ctx, resolverKey{}, r)
+}
+
+func resolverFromContext(ctx context.Context) Resolver {
+	r, _ := ctx.Value(resolverKey{}).(Resolver)
+	return r
+}
+
+type resolverChain []Resolver
+
+// LookupIP implements Resolver.
+func (c resolverChain) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := c.LookupIPTTL(ctx, host)
+	return ips, err
+}
+
+// LookupIPTTL implements TTLResolver. The TTL is that of the resolver that answered, if it's
+// a TTLResolver.
+func (c resolverChain) LookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	errs := multierror.NewMultiError(len(c) + 1)
+	for i, r := range c {
+		if err := ctx.Err(); err != nil {
+			errs.Add(err)
+			break
+		}
+		var (
+			ips []net.IP
+			ttl time.Duration
+			err error
+		)
+		if tr, ok := r.(TTLResolver); ok {
+			ips, ttl, err = tr.LookupIPTTL(ctx, host)
+		} else {
+			ips, err = r.LookupIP(ctx, host)
+		}
+		if err == nil && len(ips) > 0 {
+			return ips, ttl, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("no ips for %s", host)
+		}
+		errs.Add(fmt.Errorf("resolver %d: %w", i, err))
+	}
+	if err := errs.Err(); err != nil {
+		return nil, 0, err
+	}
+	return nil, 0, fmt.Errorf("s3transport: no resolvers for %s", host)
+}
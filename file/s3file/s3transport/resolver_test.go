@@ -0,0 +1,119 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolverChain(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var calls []string
+	failing := ResolverFunc(func(ctx context.Context, host string) ([]net.IP, error) {
+		calls = append(calls, "failing")
+		return nil, errors.New("resolver outage")
+	})
+	empty := ResolverFunc(func(ctx context.Context, host string) ([]net.IP, error) {
+		calls = append(calls, "empty")
+		return nil, nil
+	})
+	working := ResolverFunc(func(ctx context.Context, host string) ([]net.IP, error) {
+		calls = append(calls, "working")
+		return testIPs(2), nil
+	})
+	rt := New(srv.factory, WithResolverChain(failing, empty, working))
+	defer rt.Close()
+
+	var h History
+	_, err := get(RecordAttempts(context.Background(), &h), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"failing", "empty", "working"}, calls)
+	require.Len(t, h.Attempts(), 1)
+	assert.Equal(t, testIPs(2)[0].String(), h.Attempts()[0].IP.String())
+}
+
+func TestResolverChainFails(t *testing.T) {
+	chain := resolverChain{
+		ResolverFunc(func(context.Context, string) ([]net.IP, error) { return nil, errors.New("first") }),
+		ResolverFunc(func(context.Context, string) ([]net.IP, error) { return nil, errors.New("second") }),
+	}
+	_, err := chain.LookupIP(context.Background(), "s3.example.com")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "first")
+	assert.Contains(t, err.Error(), "second")
+
+	var calls int
+	ctx, cancel := context.WithCancel(context.Background())
+	chain = resolverChain{
+		ResolverFunc(func(context.Context, string) ([]net.IP, error) {
+			calls++
+			cancel()
+			return nil, errors.New("first")
+		}),
+		ResolverFunc(func(context.Context, string) ([]net.IP, error) {
+			calls++
+			return testIPs(1), nil
+		}),
+	}
+	_, err = chain.LookupIP(ctx, "s3.example.com")
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+	assert.Equal(t, 1, calls, "the chain stops when the context is done")
+}
+
+func TestUseResolver(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+	var calls int
+	override := ResolverFunc(func(ctx context.Context, host string) ([]net.IP, error) {
+		calls++
+		return testIPs(2), nil
+	})
+
+	for _, c := range []struct {
+		ctx  context.Context
+		want net.IP
+	}{
+		{context.Background(), testIPs(1)[0]},
+		{UseResolver(context.Background(), override), testIPs(2)[0]},
+		{context.Background(), testIPs(1)[0]}, // The override's IP wasn't cached.
+	} {
+		var h History
+		_, err := get(RecordAttempts(c.ctx, &h), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+		require.Len(t, h.Attempts(), 1)
+		assert.Equal(t, c.want.String(), h.Attempts()[0].IP.String())
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestHostsMap(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var lookups []string
+	resolver := ResolverFunc(func(ctx context.Context, host string) ([]net.IP, error) {
+		lookups = append(lookups, host)
+		return testIPs(9), nil
+	})
+	rt := New(srv.factory, WithResolverChain(resolver),
+		WithHostsMap(map[string][]net.IP{"minio.example.com": testIPs(1, 2)}))
+	defer rt.Close()
+
+	for i := 0; i < 40; i++ {
+		_, err := get(context.Background(), rt, "http://minio.example.com:9000/")
+		require.NoError(t, err)
+		rt.CloseIdleConnections()
+	}
+	assert.Empty(t, lookups, "mapped hosts aren't resolved")
+	assert.ElementsMatch(t, []string{"10.0.0.1", "10.0.0.2"}, dedup(srv.Dialed()))
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s3.example.com"}, lookups, "other hosts are resolved")
+}
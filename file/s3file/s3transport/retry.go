@@ -0,0 +1,276 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grailbio/base/retry"
+)
+
+// maxRetryAfter bounds the server-provided Retry-After delays that are honored. Longer delays
+// are replaced by the retry policy's own.
+const maxRetryAfter = time.Minute
+
+// WithRetryPolicy makes T retry requests that fail with a transport error or a retriable status
+// (429 or 5xx), waiting between attempts as policy dictates. WithRetryStickiness decides which
+// IPs retries are sent to. Requests whose body can't be replayed (a non-nil Body without
+// GetBody) aren't retried, since a retry would send a truncated body; their first response
+// or error is returned. Replayed bodies are recreated with GetBody for each attempt. For 429
+// and 503 responses with a Retry-After header of up to a minute, that delay is used instead
+// of the policy's. If the delay would pass the request context's deadline, the last response
+// or error is returned without waiting.
+//
+// By default, T doesn't retry; callers like the AWS SDK typically have their own retries.
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(t *T) { t.retryPolicy = policy }
+}
+
+// WithRetryDeadline bounds the total time spent on a request, from the start of RoundTrip
+// (including DNS resolution, dials, and attempts), after which it's no longer retried, even if
+// the retry policy would allow it. The last response or error is returned instead. Unlike a
+// context deadline, it doesn't interrupt an attempt in progress; it limits retry amplification.
+func WithRetryDeadline(d time.Duration) Option {
+	return func(t *T) { t.retryDeadline = d }
+}
+
+// RetryStickiness determines which IPs T's retries are sent to. See WithRetryStickiness.
+type RetryStickiness int
+
+const (
+	// RetryStickAfterConnect retries on the same IP if the attempt failed with an error after
+	// getting a connection, as when reading the response fails, and otherwise rebalances. It's
+	// the default. Connection errors suggest the IP is unhealthy, whereas later errors are
+	// often transient, and the retry may reuse one of the IP's warm connections.
+	RetryStickAfterConnect RetryStickiness = iota
+	// RetryRebalance always rebalances.
+	RetryRebalance
+	// RetryStick always retries on the same IP, including after retriable responses.
+	RetryStick
+)
+
+// WithRetryStickiness sets whether WithRetryPolicy's retries go to the same IP as the attempt
+// they retry, or rebalance. A rebalanced retry goes to another IP if the host has one that
+// isn't excluded. A sticky retry is rebalanced if its IP is no longer a candidate.
+func WithRetryStickiness(policy RetryStickiness) Option {
+	return func(t *T) { t.retryStickiness = policy }
+}
+
+// retryIPKey is the context key for the retryIP that a retry's IP is chosen by.
+type retryIPKey struct{}
+
+// retryIP identifies the IP an attempt was sent to, and whether its retry should go to the
+// same IP (stick) or another (rebalance).
+type retryIP struct {
+	ip    net.IP
+	stick bool
+}
+
+// sticks reports whether the retry of an attempt that ended with err should stick to the
+// attempt's IP.
+func (t *T) sticks(result attemptResult, err error) bool {
+	switch t.retryStickiness {
+	case RetryRebalance:
+		return false
+	case RetryStick:
+		return true
+	default:
+		return err != nil && result.connected
+	}
+}
+
+// retryCandidates narrows candidates according to ctx's retryIP, if any: to the previous
+// attempt's IP, for sticky retries, and to the others, for rebalanced ones. If that would
+// leave no candidates, they're returned unchanged.
+func retryCandidates(ctx context.Context, candidates []net.IP) []net.IP {
+	prev, ok := ctx.Value(retryIPKey{}).(retryIP)
+	if !ok || prev.ip == nil {
+		return candidates
+	}
+	narrowed := make([]net.IP, 0, len(candidates))
+	for _, ip := range candidates {
+		if ip.Equal(prev.ip) == prev.stick {
+			narrowed = append(narrowed, ip)
+		}
+	}
+	if len(narrowed) == 0 {
+		return candidates
+	}
+	return narrowed
+}
+
+// roundTripWithRetries sends req to host's ips with rt, retrying as configured. start is when
+// the request started.
+func (t *T) roundTripWithRetries(
+	rt http.RoundTripper, req *http.Request, host string, ips []net.IP, start time.Time,
+) (*http.Response, error) {
+	ctx := req.Context()
+	replayable := isReplayable(req)
+	var prev retryIP
+	for retries := 0; ; retries++ {
+		if retries > 0 {
+			var err error
+			if req, err = rewound(context.WithValue(ctx, retryIPKey{}, prev), req); err != nil {
+				return nil, err
+			}
+		}
+		resp, result, err := t.attempt(rt, req, host, ips)
+		if !replayable || !isRetriable(ctx, resp, err) {
+			return resp, err
+		}
+		keepGoing, delay := t.retryPolicy.Retry(retries)
+		if !keepGoing {
+			return resp, exhausted(retries+1, err)
+		}
+		if d, ok := retryAfter(resp, time.Now()); ok {
+			delay = d
+		}
+		next := time.Now().Add(delay)
+		if deadline, ok := ctx.Deadline(); ok && next.After(deadline) {
+			return resp, err
+		}
+		if t.retryDeadline > 0 && next.Sub(start) > t.retryDeadline {
+			return resp, exhausted(retries+1, err)
+		}
+		prev = retryIP{ip: result.ip, stick: t.sticks(result, err)}
+		t.emitOutcome(EventRetried, host, resp, err, delay)
+		discardResponse(resp)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ErrRetriesExhausted is matched, with errors.Is, by the errors RoundTrip returns when a
+// request failed with a retriable error on every attempt allowed by WithRetryPolicy or
+// WithRetryDeadline. Such errors are *RetriesExhaustedError. Requests whose last attempt
+// got a retriable status (429 or 5xx) return that response instead, without an error.
+var ErrRetriesExhausted = errors.New("s3transport: retries exhausted")
+
+// RetriesExhaustedError is the error of a request whose retries were exhausted.
+type RetriesExhaustedError struct {
+	// Attempts is the number of attempts made.
+	Attempts int
+	// Err is the last attempt's error.
+	Err error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("s3transport: retries exhausted after %d attempts: %v", e.Attempts, e.Err)
+}
+
+// Unwrap returns the last attempt's error.
+func (e *RetriesExhaustedError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrRetriesExhausted.
+func (e *RetriesExhaustedError) Is(target error) bool { return target == ErrRetriesExhausted }
+
+// Timeout reports whether the last attempt's error was a timeout, as net.Error does, so
+// that callers like http.Client still recognize timeouts.
+func (e *RetriesExhaustedError) Timeout() bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(e.Err, &timeout) && timeout.Timeout()
+}
+
+// exhausted returns err, the error of the last of attempts, wrapped in a
+// RetriesExhaustedError, or nil if err is nil.
+func exhausted(attempts int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RetriesExhaustedError{Attempts: attempts, Err: err}
+}
+
+// isRetriable reports whether an attempt's outcome is worth retrying.
+func isRetriable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		// Errors from T itself (rather than the attempt) won't resolve with retries.
+		return !errors.Is(err, ErrAllIPsExcluded)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryAfter returns the delay requested by resp's Retry-After header, if resp is a 429 or
+// 503 and the delay is at most maxRetryAfter. The header may be delay-seconds or an HTTP-date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil ||
+		(resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		if delay = date.Sub(now); delay < 0 {
+			delay = 0
+		}
+	} else {
+		return 0, false
+	}
+	if delay > maxRetryAfter {
+		return 0, false
+	}
+	return delay, true
+}
+
+// discardResponse reads a bit of resp's body, so the connection may be reused, and closes it.
+func discardResponse(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	_, _ = io.CopyN(ioutil.Discard, resp.Body, 4<<10)
+	_ = resp.Body.Close()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isReplayable reports whether req can be sent again, that is, whether its body is empty or
+// can be recreated with GetBody.
+func isReplayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// errNotReplayable is returned by rewound for requests that aren't replayable.
+var errNotReplayable = errors.New("s3transport: request body can't be replayed")
+
+// rewound returns a copy of req with context ctx and a fresh body. It fails if req isn't
+// replayable, since its body may already have been read.
+func rewound(ctx context.Context, req *http.Request) (*http.Request, error) {
+	if !isReplayable(req) {
+		return nil, errNotReplayable
+	}
+	clone := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
@@ -0,0 +1,270 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryAfterParsing(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		status int
+		header string
+		want   time.Duration
+		wantOK bool
+	}{
+		{http.StatusServiceUnavailable, "3", 3 * time.Second, true},
+		{http.StatusTooManyRequests, "0", 0, true},
+		{http.StatusServiceUnavailable, "Thu, 01 Oct 2020 12:00:07 GMT", 7 * time.Second, true},
+		{http.StatusTooManyRequests, "Thu, 01 Oct 2020 11:59:00 GMT", 0, true},
+		{http.StatusServiceUnavailable, "3600", 0, false}, // Beyond maxRetryAfter.
+		{http.StatusServiceUnavailable, "soon", 0, false},
+		{http.StatusServiceUnavailable, "", 0, false},
+		{http.StatusInternalServerError, "3", 0, false},
+	} {
+		resp := http.Response{StatusCode: c.status, Header: http.Header{}}
+		if c.header != "" {
+			resp.Header.Set("Retry-After", c.header)
+		}
+		got, ok := retryAfter(&resp, now)
+		assert.Equal(t, c.wantOK, ok, "%+v", c)
+		assert.Equal(t, c.want, got, "%+v", c)
+	}
+}
+
+// throttlingHandler responds 503 with the given Retry-After header to the first throttle
+// requests, and succeeds afterwards.
+func throttlingHandler(throttle int32, retryAfter string, requests *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= throttle {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	var requests int32
+	srv := newTestServer(throttlingHandler(1, "1", &requests))
+	defer srv.Close()
+	// The policy's own delay is too long for the test to finish unless Retry-After is used.
+	policy := retry.MaxRetries(retry.Backoff(time.Hour, time.Hour, 1), 3)
+	rt := newTestT(srv.factory, testIPs(1), WithRetryPolicy(policy))
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	resp, err := get(ctx, rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	var requests int32
+	// HTTP-dates have second granularity, so this may round to an immediate retry.
+	date := time.Now().Add(time.Second).UTC().Format(http.TimeFormat)
+	srv := newTestServer(throttlingHandler(1, date, &requests))
+	defer srv.Close()
+	policy := retry.MaxRetries(retry.Backoff(time.Hour, time.Hour, 1), 3)
+	rt := newTestT(srv.factory, testIPs(1), WithRetryPolicy(policy))
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := get(ctx, rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+}
+
+func TestRetryAfterPastDeadline(t *testing.T) {
+	var requests int32
+	srv := newTestServer(throttlingHandler(1, "30", &requests))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithRetryPolicy(retry.MaxRetries(nil, 3)))
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	resp, err := get(ctx, rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requests))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "shouldn't wait for the deadline")
+}
+
+func TestRetryDeadline(t *testing.T) {
+	var requests int32
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1),
+		WithRetryPolicy(retry.MaxRetries(nil, 100)), WithRetryDeadline(250*time.Millisecond))
+	defer rt.Close()
+
+	start := time.Now()
+	resp, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	// Attempts take (at least) 100ms each, so at most three fit within the deadline.
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&requests), int32(2))
+	assert.LessOrEqual(t, atomic.LoadInt32(&requests), int32(3))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestRetryStickinessConnectErrors(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	ips := testIPs(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	policy := retry.MaxRetries(retry.Backoff(time.Millisecond, time.Millisecond, 1), 3)
+	rt := newTestT(refusingFactory(srv, ips[:5]...), ips, WithRetryPolicy(policy))
+	defer rt.Close()
+
+	for i := 0; i < 10; i++ {
+		var h History
+		// The request may fail, if every attempt happens to go to a refusing IP.
+		_, _ = get(RecordAttempts(context.Background(), &h), rt, "http://s3.example.com/")
+		attempts := h.Attempts()
+		for j := 1; j < len(attempts); j++ {
+			assert.False(t, attempts[j-1].IP.Equal(attempts[j].IP),
+				"connection error on %v retried on the same IP", attempts[j].IP)
+		}
+	}
+}
+
+func TestRetryStickinessReadErrors(t *testing.T) {
+	var requests int32
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if assert.NoError(t, err) {
+			_ = conn.Close()
+		}
+	}))
+	defer srv.Close()
+	policy := retry.MaxRetries(retry.Backoff(time.Millisecond, time.Millisecond, 1), 3)
+
+	for _, c := range []struct {
+		policy RetryStickiness
+		same   bool
+	}{
+		{RetryStickAfterConnect, true},
+		{RetryRebalance, false},
+	} {
+		atomic.StoreInt32(&requests, 0)
+		rt := newTestT(srv.factory, testIPs(1, 2, 3, 4, 5, 6, 7, 8, 9, 10),
+			WithRetryPolicy(policy), WithRetryStickiness(c.policy))
+		var h History
+		_, err := get(RecordAttempts(context.Background(), &h), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+		attempts := h.Attempts()
+		require.Len(t, attempts, 2)
+		assert.Error(t, attempts[0].Err)
+		assert.Equal(t, c.same, attempts[0].IP.Equal(attempts[1].IP), "policy %v", c.policy)
+		rt.Close()
+	}
+}
+
+// bodyRecordingHandler records request bodies and responds 503 to the first throttle requests.
+func bodyRecordingHandler(throttle int, mu *sync.Mutex, bodies *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		*bodies = append(*bodies, string(body))
+		n := len(*bodies)
+		mu.Unlock()
+		if n <= throttle {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func TestRetryNonReplayableBody(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	srv := newTestServer(bodyRecordingHandler(2, &mu, &bodies))
+	defer srv.Close()
+	policy := retry.MaxRetries(retry.Backoff(time.Millisecond, time.Millisecond, 1), 3)
+	rt := newTestT(srv.factory, testIPs(1), WithRetryPolicy(policy))
+	defer rt.Close()
+
+	req, err := http.NewRequest(http.MethodPut, "http://s3.example.com/key",
+		ioutil.NopCloser(strings.NewReader("data")))
+	require.NoError(t, err)
+	require.Nil(t, req.GetBody)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "the first response is returned")
+	assert.Equal(t, []string{"data"}, bodies, "the request isn't retried")
+
+	_, err = rewound(context.Background(), req)
+	assert.Equal(t, errNotReplayable, err)
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	srv := newTestServer(bodyRecordingHandler(2, &mu, &bodies))
+	defer srv.Close()
+	policy := retry.MaxRetries(retry.Backoff(time.Millisecond, time.Millisecond, 1), 3)
+	rt := newTestT(srv.factory, testIPs(1), WithRetryPolicy(policy))
+	defer rt.Close()
+
+	req, err := http.NewRequest(http.MethodPut, "http://s3.example.com/key", strings.NewReader("data"))
+	require.NoError(t, err)
+	require.NotNil(t, req.GetBody)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"data", "data", "data"}, bodies, "each attempt sends the whole body")
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	policy := retry.MaxRetries(retry.Backoff(time.Millisecond, time.Millisecond, 1), 2)
+	rt := newTestT(refusingFactory(srv, testIPs(1)...), testIPs(1), WithRetryPolicy(policy))
+	defer rt.Close()
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrRetriesExhausted))
+	var exhausted *RetriesExhaustedError
+	require.True(t, errors.As(err, &exhausted))
+	assert.Equal(t, 3, exhausted.Attempts)
+	assert.EqualError(t, exhausted.Err, "connection refused")
+	assert.False(t, exhausted.Timeout())
+
+	// Failures that aren't retried aren't exhausted retries.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = get(ctx, rt, "http://s3.example.com/")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrRetriesExhausted))
+}
@@ -0,0 +1,96 @@
+package s3transport
+
+import (
+	"net"
+	"time"
+)
+
+// rotationWindow is how long a host must resolve only to new IPs before its remembered IPs
+// are considered rotated.
+const rotationWindow = 5 * time.Minute
+
+// RotationPolicy determines what T does with the IPs it remembers for a host when the host
+// resolves only to new IPs for a while, as when a service rotates its whole fleet. See
+// WithRotationPolicy.
+type RotationPolicy int
+
+const (
+	// RotationMerge adds the new IPs to the remembered ones, which remain candidates until
+	// they expire. It's the default.
+	RotationMerge RotationPolicy = iota
+	// RotationReplace forgets the previously remembered IPs and closes the host's idle
+	// connections, so that requests immediately go only to the new IPs. It's the safest
+	// policy for deliberate rotations.
+	RotationReplace
+	// RotationDrain forgets the previously remembered IPs, so that new requests go only to
+	// the new IPs, but leaves connections to the old IPs open, so that in-flight requests
+	// finish and idle connections time out as usual.
+	RotationDrain
+)
+
+// WithRotationPolicy sets the policy for hosts whose IPs are rotated: those that, for five
+// minutes, resolve only to IPs that T didn't already remember for them. A single answer
+// that's disjoint from the remembered IPs isn't a rotation, since services like S3 answer
+// each lookup with a different subset of a large pool of IPs. Detection is still heuristic
+// for such services: if the pool is much larger than the IPs T remembers, five minutes of
+// answers may miss all of them, so RotationReplace and RotationDrain may forget IPs that are
+// still in service. The policy applies only to T's in-memory cache, not to an IPCache given
+// by WithIPCache.
+func WithRotationPolicy(policy RotationPolicy) Option {
+	return func(t *T) { t.rotationPolicy = policy }
+}
+
+// rotate is like addAndGet, except that if host has IPs, and no answer since rotationWindow
+// ago has included any of them other than the ones first seen in that time, those old IPs are
+// forgotten, and rotated is true.
+func (s *expiringMap) rotate(host string, newIPs []net.IP, ttl time.Duration) (allIPs []net.IP, rotated bool) {
+	s.mu.Lock()
+	now := s.now()
+	if ips, ok := s.elems[host]; ok && len(newIPs) > 0 {
+		times := s.hostTimes[host]
+		overlaps := false
+		for _, ip := range newIPs {
+			if _, ok := ips[elemKey(ip)]; ok && !times.pending[elemKey(ip)] {
+				overlaps = true
+				break
+			}
+		}
+		switch {
+		case overlaps:
+			times.overlapped, times.pending = now, nil
+		case now.Sub(times.overlapped) >= rotationWindow:
+			rotated = true
+			for ip := range ips {
+				if !times.pending[ip] {
+					delete(ips, ip)
+				}
+			}
+			times.overlapped, times.pending = now, nil
+		default:
+			if times.pending == nil {
+				times.pending = map[string]bool{}
+			}
+			for _, ip := range newIPs {
+				times.pending[elemKey(ip)] = true
+			}
+		}
+		s.hostTimes[host] = times
+	}
+	// The old IPs are replaced under the same lock hold as the check, so that concurrent
+	// rotations don't interleave.
+	allIPs, evicted := s.add(host, newIPs, ttl, true)
+	s.mu.Unlock()
+	s.evicted(evicted)
+	return allIPs, rotated
+}
+
+// closeIdleConnections closes the idle connections of host's transports.
+func (t *T) closeIdleConnections(host string) {
+	t.hostRTsMu.Lock()
+	defer t.hostRTsMu.Unlock()
+	for key, rt := range t.hostRTs {
+		if c, ok := rt.(interface{ CloseIdleConnections() }); ok && key.host == host {
+			c.CloseIdleConnections()
+		}
+	}
+}
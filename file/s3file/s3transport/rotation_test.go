@@ -0,0 +1,116 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotationPolicy(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		policy     RotationPolicy
+		candidates []net.IP
+		// oldConnsOpen is whether connections to the old IPs stay open after rotation.
+		oldConnsOpen bool
+	}{
+		{"merge", RotationMerge, testIPs(1, 2, 3, 4), true},
+		{"replace", RotationReplace, testIPs(3, 4), false},
+		{"drain", RotationDrain, testIPs(3, 4), true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			srv := newTestServer(okHandler())
+			defer srv.Close()
+			rt := newTestT(srv.factory, nil, WithRotationPolicy(c.policy))
+			defer rt.Close()
+			var (
+				mu       sync.Mutex
+				now      = time.Now()
+				resolves = testIPs(1, 2)
+			)
+			rt.resolver = newResolver(
+				func(string) ([]net.IP, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					return resolves, nil
+				},
+				func() time.Time {
+					mu.Lock()
+					defer mu.Unlock()
+					return now
+				})
+			rt.hostIPs.now = rt.resolver.now
+
+			for i := 0; i < 10; i++ {
+				_, err := get(context.Background(), rt, "http://s3.example.com/")
+				require.NoError(t, err)
+			}
+			require.ElementsMatch(t, testIPs(1, 2), rt.hostIPs.AddAndGet("s3.example.com", nil))
+			openOld := func() (open int64) {
+				for _, s := range rt.ConnStats("s3.example.com") {
+					if s.IP.Equal(testIPs(1)[0]) || s.IP.Equal(testIPs(2)[0]) {
+						open += s.OpenConns
+					}
+				}
+				return
+			}
+			require.NotZero(t, openOld())
+
+			// A disjoint answer isn't a rotation until no answer has included the previously
+			// remembered IPs for rotationWindow.
+			mu.Lock()
+			now = now.Add(time.Minute)
+			resolves = testIPs(3, 4)
+			mu.Unlock()
+			_, err := get(context.Background(), rt, "http://s3.example.com/")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, testIPs(1, 2, 3, 4), rt.hostIPs.AddAndGet("s3.example.com", nil))
+			require.NotZero(t, openOld())
+
+			mu.Lock()
+			now = now.Add(rotationWindow)
+			mu.Unlock()
+			_, err = get(context.Background(), rt, "http://s3.example.com/")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, c.candidates, rt.hostIPs.AddAndGet("s3.example.com", nil))
+			if c.oldConnsOpen {
+				assert.NotZero(t, openOld())
+			} else {
+				assert.Eventually(t, func() bool { return openOld() == 0 }, 5*time.Second, time.Millisecond)
+			}
+
+			// A partial rotation (overlapping IPs) merges, under every policy.
+			mu.Lock()
+			now = now.Add(time.Minute)
+			resolves = testIPs(4, 5)
+			mu.Unlock()
+			_, err = get(context.Background(), rt, "http://s3.example.com/")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, append(c.candidates, testIPs(5)...),
+				rt.hostIPs.AddAndGet("s3.example.com", nil))
+		})
+	}
+}
+
+func TestRotateVaryingAnswers(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	s := makeExpiringMap(func() time.Time { return now })
+	// Like S3, each answer is a different subset of a pool, but some of the remembered IPs
+	// keep reappearing, so the host's IPs are never rotated.
+	for i, answer := range [][]net.IP{
+		testIPs(1, 2), testIPs(3, 4), testIPs(5, 6), testIPs(1, 7), testIPs(8, 9), testIPs(10, 11),
+	} {
+		_, rotated := s.rotate("s3.example.com", answer, expireAfter)
+		assert.False(t, rotated, "answer %d", i)
+		now = now.Add(rotationWindow / 3)
+	}
+	_, rotated := s.rotate("s3.example.com", testIPs(12), expireAfter)
+	assert.True(t, rotated, "no remembered IP has reappeared for rotationWindow")
+	assert.ElementsMatch(t, testIPs(8, 9, 10, 11, 12), s.Get("s3.example.com"),
+		"IPs first seen within the window are kept")
+}
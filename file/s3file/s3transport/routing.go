@@ -0,0 +1,34 @@
+package s3transport
+
+import (
+	"net/http"
+	"strings"
+)
+
+// WithMethodRouting makes T send requests whose methods are keys of hosts to the
+// corresponding hostnames, before resolving them, for deployments with separate read and
+// write endpoints (for example, {"GET": "replica.example.com", "HEAD": "replica.example.com"}).
+// The URL's port, if any, is kept. As with WithRegionalEndpoint, the Host header is also
+// rewritten, so signed requests must have been signed for the new host. Requests with other
+// methods go to their original host.
+func WithMethodRouting(hosts map[string]string) Option {
+	routes := make(map[string]string, len(hosts))
+	for method, host := range hosts {
+		routes[strings.ToUpper(method)] = host
+	}
+	return func(t *T) { t.methodRoutes = routes }
+}
+
+// methodRouted returns req, rewritten for WithMethodRouting if necessary. req itself is not
+// modified.
+func (t *T) methodRouted(req *http.Request) *http.Request {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	host, ok := t.methodRoutes[method]
+	if !ok {
+		return req
+	}
+	return withHostname(req, host)
+}
@@ -0,0 +1,54 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethodRouting(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		looked, hosts []string
+	)
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Method+" "+r.Host)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, nil, WithMethodRouting(map[string]string{
+		"get": "replica.example.com",
+		"PUT": "primary.example.com",
+	}))
+	defer rt.Close()
+	rt.resolver = newResolver(func(host string) ([]net.IP, error) {
+		mu.Lock()
+		looked = append(looked, host)
+		mu.Unlock()
+		return testIPs(1), nil
+	}, time.Now)
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		req, err := http.NewRequestWithContext(context.Background(), method,
+			"http://s3.example.com:9000/bucket/key", strings.NewReader("data"))
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err, method)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, "s3.example.com:9000", req.URL.Host, "the caller's request is unmodified")
+	}
+	assert.Equal(t, []string{"replica.example.com", "primary.example.com", "s3.example.com"}, looked)
+	assert.Equal(t, []string{
+		"GET replica.example.com:9000",
+		"PUT primary.example.com:9000",
+		"DELETE s3.example.com:9000",
+	}, hosts)
+}
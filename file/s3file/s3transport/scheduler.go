@@ -0,0 +1,107 @@
+package s3transport
+
+import (
+	"sync"
+	"time"
+)
+
+// Scheduler runs the periodic background work (like cache expiry) of many T instances on a
+// single goroutine. Without one, each T starts its own goroutines.
+type Scheduler struct {
+	// wake is signaled when a task is added, so the loop can recompute its next deadline.
+	wake chan struct{}
+	done chan struct{}
+
+	mu    sync.Mutex
+	tasks []*scheduledTask
+}
+
+type scheduledTask struct {
+	period time.Duration
+	next   time.Time
+	tick   func(time.Time)
+	done   <-chan struct{}
+}
+
+// NewScheduler returns a Scheduler and starts its goroutine. Close stops it.
+func NewScheduler() *Scheduler {
+	s := Scheduler{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go s.loop()
+	return &s
+}
+
+// Close stops s's goroutine. T instances using s stop their background work, so they should
+// be closed first. Close must be called at most once.
+func (s *Scheduler) Close() {
+	close(s.done)
+}
+
+// WithSharedScheduler makes T run its background work on s instead of its own goroutines.
+func WithSharedScheduler(s *Scheduler) Option {
+	return func(t *T) { t.scheduler = s }
+}
+
+// runPeriodic implements runPeriodic.
+func (s *Scheduler) runPeriodic(period time.Duration, tick func(time.Time), done <-chan struct{}) {
+	s.mu.Lock()
+	s.tasks = append(s.tasks, &scheduledTask{period, time.Now().Add(period), tick, done})
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) loop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		next := s.runDue(time.Now())
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// runDue runs the tasks that are due at now, drops tasks that are done, and returns when the
+// next task is due.
+func (s *Scheduler) runDue(now time.Time) (next time.Time) {
+	var due []*scheduledTask
+	s.mu.Lock()
+	next = now.Add(time.Hour)
+	live := s.tasks[:0]
+	for _, task := range s.tasks {
+		select {
+		case <-task.done:
+			continue
+		default:
+		}
+		live = append(live, task)
+		if !now.Before(task.next) {
+			due = append(due, task)
+			task.next = now.Add(task.period)
+		}
+		if task.next.Before(next) {
+			next = task.next
+		}
+	}
+	for i := len(live); i < len(s.tasks); i++ {
+		s.tasks[i] = nil
+	}
+	s.tasks = live
+	s.mu.Unlock()
+	for _, task := range due {
+		task.tick(now)
+	}
+	return next
+}
@@ -0,0 +1,46 @@
+package s3transport
+
+import (
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedScheduler(t *testing.T) {
+	s := NewScheduler()
+	defer s.Close()
+
+	before := runtime.NumGoroutine()
+	const n = 20
+	var rts []*T
+	for i := 0; i < n; i++ {
+		rts = append(rts, New(http.DefaultTransport.(*http.Transport).Clone, WithSharedScheduler(s)))
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "transports should reuse the scheduler's goroutine")
+	s.mu.Lock()
+	assert.Len(t, s.tasks, n)
+	s.mu.Unlock()
+
+	for _, rt := range rts {
+		rt.Close()
+	}
+	s.runDue(time.Now())
+	s.mu.Lock()
+	assert.Empty(t, s.tasks, "closed transports' tasks should be dropped")
+	s.mu.Unlock()
+}
+
+func TestSchedulerRunsTasks(t *testing.T) {
+	s := NewScheduler()
+	defer s.Close()
+	var ticks int32
+	done := make(chan struct{})
+	s.runPeriodic(time.Millisecond, func(time.Time) { atomic.AddInt32(&ticks, 1) }, done)
+	time.Sleep(50 * time.Millisecond)
+	close(done)
+	assert.Greater(t, atomic.LoadInt32(&ticks), int32(2))
+}
@@ -0,0 +1,91 @@
+package s3transport
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/grailbio/base/log"
+)
+
+// WithServerNameForIP makes T verify, and send as SNI, the TLS server name f(host, ip) for
+// requests for host sent to ip, rather than host, for S3-compatible services whose frontends
+// expect different names. If f returns "", host is used. Each distinct name gets its own
+// transport, and so its own connections.
+func WithServerNameForIP(f func(host string, ip net.IP) string) Option {
+	return func(t *T) { t.serverNameForIP = f }
+}
+
+// ipRoundTripper returns the transport for sending req, which is for host, to ip: rt, host's
+// transport, unless WithServerNameForIP gives ip a different server name.
+func (t *T) ipRoundTripper(rt http.RoundTripper, req *http.Request, host string, ip net.IP) (http.RoundTripper, error) {
+	if t.serverNameForIP == nil || req.URL.Scheme == "http" {
+		return rt, nil
+	}
+	name := t.serverName(host, ip)
+	if name == t.ServerName(host) {
+		return rt, nil
+	}
+	return t.hostRoundTripper(hostKey{
+		host: host, serverName: name, fresh: freshConnFromContext(req.Context()),
+	})
+}
+
+// serverName returns the TLS server name for host's ip.
+func (t *T) serverName(host string, ip net.IP) string {
+	if t.serverNameForIP != nil {
+		if name := t.serverNameForIP(host, ip); name != "" {
+			return name
+		}
+	}
+	return t.ServerName(host)
+}
+
+// WithServerNameOverride makes T verify, and send as SNI, the TLS server name name for
+// requests for host (a URL hostname, without port), rather than host itself, for endpoints
+// whose certificates don't match their DNS names. It applies to IP literal hosts, too, which
+// are otherwise verified against the IP. WithServerNameForIP takes precedence.
+func WithServerNameOverride(host, name string) Option {
+	return func(t *T) {
+		if t.serverNameOverrides == nil {
+			t.serverNameOverrides = map[string]string{}
+		}
+		t.serverNameOverrides[host] = name
+	}
+}
+
+// ServerName returns the TLS server name T uses for HTTPS requests for host (a URL
+// hostname, without port): host itself, unless WithServerNameOverride overrides it.
+// WithServerNameForIP may give particular IPs other names.
+func (t *T) ServerName(host string) string {
+	if name, ok := t.serverNameOverrides[host]; ok {
+		return name
+	}
+	return host
+}
+
+// WithTLSVerifyHook makes T call f after each TLS handshake with one of host's IPs, with
+// the server name the certificate was verified against and the handshake's error, if any,
+// to help attribute certificate problems to particular frontends. Regardless, handshakes
+// that fail because the certificate doesn't match the server name are logged and reported
+// as MetricServerNameMismatch.
+func WithTLSVerifyHook(f func(host string, ip net.IP, serverName string, err error)) Option {
+	return func(t *T) { t.tlsVerifyHook = f }
+}
+
+// tlsHandshakeDone reports the outcome, err, of a TLS handshake with host's ip.
+func (t *T) tlsHandshakeDone(host string, ip net.IP, err error) {
+	var mismatch x509.HostnameError
+	if t.tlsVerifyHook == nil && !errors.As(err, &mismatch) {
+		return
+	}
+	name := t.serverName(host, ip)
+	if t.tlsVerifyHook != nil {
+		t.tlsVerifyHook(host, ip, name, err)
+	}
+	if errors.As(err, &mismatch) {
+		log.Printf("s3transport: %s: certificate of %s doesn't match server name %q: %v", host, ip, name, err)
+		t.observeIP(MetricServerNameMismatch, host, ip, 1)
+	}
+}
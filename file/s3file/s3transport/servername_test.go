@@ -0,0 +1,118 @@
+package s3transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSNIServer starts a TLS server that records the server names its clients send. Callers
+// must Close it.
+func newSNIServer() (*httptest.Server, func() []string) {
+	var (
+		mu    sync.Mutex
+		names []string
+	)
+	srv := httptest.NewUnstartedServer(okHandler())
+	srv.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			names = append(names, hello.ServerName)
+			mu.Unlock()
+			return nil, nil
+		},
+	}
+	srv.StartTLS()
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), names...)
+	}
+}
+
+func TestServerNameForIP(t *testing.T) {
+	srv, names := newSNIServer()
+	defer srv.Close()
+	rt := newTestT(tlsFactory(srv, 4), testIPs(1, 2), WithServerNameForIP(func(host string, ip net.IP) string {
+		if ip.Equal(testIPs(1)[0]) {
+			return "tenant-a." + host
+		}
+		return ""
+	}))
+	defer rt.Close()
+
+	only1 := ExcludeIPs(context.Background(), testIPs(2)...)
+	only2 := ExcludeIPs(context.Background(), testIPs(1)...)
+	for _, ctx := range []context.Context{only1, only2, only1} {
+		_, err := get(ctx, rt, "https://s3.example.com/")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"tenant-a.s3.example.com", "s3.example.com"}, names(),
+		"connections are reused per server name")
+}
+
+func TestServerNameMismatch(t *testing.T) {
+	srv := httptest.NewTLSServer(okHandler())
+	defer srv.Close()
+	// Verify certificates (for example.com) rather than skipping verification.
+	factory := func() *http.Transport {
+		transport := tlsFactory(srv, 4)()
+		transport.TLSClientConfig = &tls.Config{RootCAs: x509.NewCertPool()}
+		transport.TLSClientConfig.RootCAs.AddCert(srv.Certificate())
+		return transport
+	}
+	type verification struct {
+		host, ip, serverName string
+		failed               bool
+	}
+	var (
+		mu        sync.Mutex
+		verified  []verification
+		collector recordingCollector
+	)
+	rt := newTestT(factory, testIPs(1), WithMetrics(&collector),
+		WithTLSVerifyHook(func(host string, ip net.IP, serverName string, err error) {
+			mu.Lock()
+			verified = append(verified, verification{host, ip.String(), serverName, err != nil})
+			mu.Unlock()
+		}))
+	defer rt.Close()
+
+	_, err := get(context.Background(), rt, "https://example.com/")
+	require.NoError(t, err)
+	_, err = get(context.Background(), rt, "https://s3.example.org/")
+	require.Error(t, err)
+
+	assert.Equal(t, []verification{
+		{"example.com", "10.0.0.1", "example.com", false},
+		{"s3.example.org", "10.0.0.1", "s3.example.org", true},
+	}, verified)
+	mismatches := collector.Named(MetricServerNameMismatch)
+	require.Len(t, mismatches, 1)
+	assert.Equal(t, "s3.example.org", mismatches[0].Host)
+	assert.Equal(t, "10.0.0.1", mismatches[0].IP)
+}
+
+func TestServerNameOverride(t *testing.T) {
+	rt := New(httpTransport.Clone, WithServerNameOverride("s3.example.com", "frontend.example.net"))
+	defer rt.Close()
+	assert.Equal(t, "frontend.example.net", rt.ServerName("s3.example.com"))
+	assert.Equal(t, "other.example.com", rt.ServerName("other.example.com"))
+
+	for host, want := range map[string]string{
+		"s3.example.com":    "frontend.example.net",
+		"other.example.com": "other.example.com",
+	} {
+		hostRT, err := rt.hostRoundTripper(hostKey{host: host})
+		require.NoError(t, err)
+		assert.Equal(t, want, hostRT.(*http.Transport).TLSClientConfig.ServerName)
+	}
+}
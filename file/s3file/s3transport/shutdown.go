@@ -0,0 +1,51 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether requests have finished.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// ErrShutdown is returned by RoundTrip after Shutdown is called.
+var ErrShutdown = errors.New("s3transport: transport is shut down")
+
+// Shutdown gracefully stops t: new requests fail with ErrShutdown, and Shutdown waits for
+// requests in progress, including the reading of their response bodies, to finish before
+// closing t, as Close does. If ctx is done first, t is closed anyway, without interrupting the
+// remaining requests, and ctx's error is returned. Response bodies from IP literal hosts aren't
+// waited for.
+func (t *T) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&t.shutDown, 1)
+	defer t.Close()
+	for t.active() {
+		if err := sleep(ctx, shutdownPollInterval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// active reports whether any RoundTrip calls or attempts (whose response bodies haven't been
+// finished) are in progress.
+func (t *T) active() bool {
+	if atomic.LoadInt64(&t.roundTrips) > 0 {
+		return true
+	}
+	t.hostsMu.Lock()
+	defer t.hostsMu.Unlock()
+	for _, s := range t.hosts {
+		s.ipsMu.Lock()
+		for _, is := range s.ips {
+			if atomic.LoadInt64(&is.inFlight) > 0 {
+				s.ipsMu.Unlock()
+				return true
+			}
+		}
+		s.ipsMu.Unlock()
+	}
+	return false
+}
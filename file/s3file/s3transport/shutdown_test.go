@@ -0,0 +1,80 @@
+package s3transport
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingBodyHandler sends response headers, then waits for release before sending the body.
+func blockingBodyHandler(release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("body"))
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	srv := newTestServer(blockingBodyHandler(release))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	bodyc := make(chan string)
+	go func() {
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, resp.Body.Close())
+		bodyc <- string(body)
+	}()
+
+	shutdownc := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdownc <- rt.Shutdown(ctx)
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&rt.shutDown) != 0 },
+		5*time.Second, time.Millisecond)
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	assert.Equal(t, ErrShutdown, err)
+	select {
+	case <-shutdownc:
+		t.Fatal("Shutdown returned while a response body was being read")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, "body", <-bodyc)
+	assert.NoError(t, <-shutdownc)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := newTestServer(blockingBodyHandler(release))
+	defer srv.Close()
+	defer close(release)
+	rt := newTestT(srv.factory, testIPs(1))
+
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, rt.Shutdown(ctx))
+}
@@ -0,0 +1,59 @@
+package s3transport
+
+import (
+	"net"
+	"time"
+)
+
+// SlowRequest describes one of a host's slowest requests.
+type SlowRequest struct {
+	// IP is the address the request was sent to.
+	IP net.IP
+	// Duration is the time until response headers (or an error) were received.
+	Duration time.Duration
+	// StatusCode is the response status, or zero if the attempt failed.
+	StatusCode int
+	// Time is when the request started.
+	Time time.Time
+}
+
+// WithSlowestRequests makes T retain, for each host, the n slowest requests (as measured by
+// Attempt.Duration), retrievable with SlowestRequests. Retained requests are forgotten when the
+// host is evicted (see WithMaxHosts). By default, none are retained.
+func WithSlowestRequests(n int) Option {
+	return func(t *T) { t.slowestPerHost = n }
+}
+
+// SlowestRequests returns host's slowest requests, slowest first. See WithSlowestRequests.
+func (t *T) SlowestRequests(host string) []SlowRequest {
+	s := t.lookupHost(host)
+	if s == nil {
+		return nil
+	}
+	s.slowMu.Lock()
+	defer s.slowMu.Unlock()
+	return append([]SlowRequest(nil), s.slowest...)
+}
+
+func (t *T) recordSlowest(host string, a Attempt) {
+	if t.slowestPerHost <= 0 {
+		return
+	}
+	s := t.host(host)
+	s.slowMu.Lock()
+	defer s.slowMu.Unlock()
+	if len(s.slowest) == t.slowestPerHost && a.Duration <= s.slowest[len(s.slowest)-1].Duration {
+		return
+	}
+	i := len(s.slowest)
+	for i > 0 && s.slowest[i-1].Duration < a.Duration {
+		i--
+	}
+	r := SlowRequest{IP: a.IP, Duration: a.Duration, StatusCode: a.StatusCode, Time: a.Start}
+	s.slowest = append(s.slowest, SlowRequest{})
+	copy(s.slowest[i+1:], s.slowest[i:])
+	s.slowest[i] = r
+	if len(s.slowest) > t.slowestPerHost {
+		s.slowest = s.slowest[:t.slowestPerHost]
+	}
+}
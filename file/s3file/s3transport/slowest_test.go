@@ -0,0 +1,40 @@
+package s3transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowestRequests(t *testing.T) {
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay, _ := time.ParseDuration(r.URL.Query().Get("delay"))
+		time.Sleep(delay)
+		if delay > 60*time.Millisecond {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1), WithSlowestRequests(2))
+	defer rt.Close()
+
+	assert.Empty(t, rt.SlowestRequests("s3.example.com"))
+	for _, ms := range []int{10, 50, 1, 70, 20} {
+		_, err := get(context.Background(), rt, fmt.Sprintf("http://s3.example.com/?delay=%dms", ms))
+		require.NoError(t, err)
+	}
+	slowest := rt.SlowestRequests("s3.example.com")
+	require.Len(t, slowest, 2)
+	assert.GreaterOrEqual(t, int64(slowest[0].Duration), int64(70*time.Millisecond))
+	assert.Equal(t, http.StatusServiceUnavailable, slowest[0].StatusCode)
+	assert.GreaterOrEqual(t, int64(slowest[1].Duration), int64(50*time.Millisecond))
+	assert.Less(t, int64(slowest[1].Duration), int64(70*time.Millisecond))
+	assert.Equal(t, http.StatusOK, slowest[1].StatusCode)
+	assert.Equal(t, "10.0.0.1", slowest[0].IP.String())
+	assert.False(t, slowest[0].Time.IsZero())
+}
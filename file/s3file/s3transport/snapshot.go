@@ -0,0 +1,97 @@
+package s3transport
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// TransportSnapshot is a snapshot of T's state and activity, across hosts, as returned by
+// Snapshot. Its totals are sums over Hosts.
+type TransportSnapshot struct {
+	Time time.Time
+	// Name is T's name. See WithName.
+	Name string
+	// Stats has T's DNS cache hit counts, etc. Its DNSCacheHitRatio is T's cache hit ratio.
+	Stats
+	// Hosts has the state of each host T has state for, ordered by host.
+	Hosts []HostSnapshot
+
+	// Requests and Errors count the requests RoundTrip has handled and those that failed, as
+	// for HostStats.
+	Requests, Errors uint64
+	// InFlight counts requests whose response bodies haven't been finished, and OpenConns the
+	// open connections, as for ConnStats.
+	InFlight, OpenConns int64
+	// EjectedIPs counts the IPs that are ejected. See IPEjected.
+	EjectedIPs int
+	// CachedHosts and CachedIPs are the sizes of T's in-memory IP cache, as for CacheSize.
+	CachedHosts, CachedIPs int
+	// Transports counts T's per-host transports.
+	Transports int
+	// Goroutines estimates the goroutines T's connections use: http.Transport runs two (a
+	// read and a write loop) per open connection.
+	Goroutines int64
+}
+
+// HostSnapshot is a snapshot of the state and activity of one of T's hosts.
+type HostSnapshot struct {
+	Host string
+	// Requests and Errors are as for HostStats.
+	Requests, Errors uint64
+	// InFlight and OpenConns are the host's in-flight requests and open connections.
+	InFlight, OpenConns int64
+	// CachedIPs counts the host's IPs in T's in-memory IP cache, and EjectedIPs its ejected
+	// ones.
+	CachedIPs, EjectedIPs int
+}
+
+// Snapshot returns a snapshot of t's state and activity across all hosts, in one pass, for
+// monitoring (for example, a /metrics or /debug handler). It's cheap enough to call every few
+// seconds, but its cost grows with the number of hosts and IPs t retains.
+func (t *T) Snapshot() TransportSnapshot {
+	snap := TransportSnapshot{Time: time.Now(), Name: t.name, Stats: t.Stats()}
+	cached := t.hostIPs.snapshot()
+	snap.CachedHosts = len(cached)
+	for _, ips := range cached {
+		snap.CachedIPs += len(ips)
+	}
+	t.hostRTsMu.Lock()
+	snap.Transports = len(t.hostRTs)
+	t.hostRTsMu.Unlock()
+
+	t.hostsMu.Lock()
+	for host, s := range t.hosts {
+		h := HostSnapshot{
+			Host:       host,
+			Requests:   atomic.LoadUint64(&s.requests),
+			Errors:     atomic.LoadUint64(&s.errors),
+			CachedIPs:  len(cached[host]),
+			EjectedIPs: len(s.ejected()),
+		}
+		s.ipsMu.Lock()
+		for _, is := range s.ips {
+			h.InFlight += atomic.LoadInt64(&is.inFlight)
+			h.OpenConns += atomic.LoadInt64(&is.openConns)
+		}
+		s.ipsMu.Unlock()
+		snap.Hosts = append(snap.Hosts, h)
+	}
+	for host, ips := range cached {
+		if _, ok := t.hosts[host]; !ok {
+			snap.Hosts = append(snap.Hosts, HostSnapshot{Host: host, CachedIPs: len(ips)})
+		}
+	}
+	t.hostsMu.Unlock()
+
+	sort.Slice(snap.Hosts, func(i, j int) bool { return snap.Hosts[i].Host < snap.Hosts[j].Host })
+	for _, h := range snap.Hosts {
+		snap.Requests += h.Requests
+		snap.Errors += h.Errors
+		snap.InFlight += h.InFlight
+		snap.OpenConns += h.OpenConns
+		snap.EjectedIPs += h.EjectedIPs
+	}
+	snap.Goroutines = 2 * snap.OpenConns
+	return snap
+}
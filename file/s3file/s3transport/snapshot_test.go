@@ -0,0 +1,64 @@
+package s3transport
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	release := make(chan struct{})
+	blocking := blockingBodyHandler(release)
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "blocking.example.com" {
+			blocking.ServeHTTP(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	rt := newTestT(refusingFactory(srv, testIPs(2)...), testIPs(1, 2, 3),
+		WithReachabilityProbe(time.Second), WithName("test"))
+	defer rt.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := get(context.Background(), rt, "http://a.example.com/")
+		require.NoError(t, err)
+	}
+	rt.Pause("b.example.com")
+	for i := 0; i < 2; i++ {
+		_, err := get(context.Background(), rt, "http://b.example.com/")
+		require.Error(t, err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://blocking.example.com/", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	snap := rt.Snapshot()
+	assert.Equal(t, "test", snap.Name)
+	require.Len(t, snap.Hosts, 3)
+	a, b, blocked := snap.Hosts[0], snap.Hosts[1], snap.Hosts[2]
+	assert.Equal(t, HostSnapshot{
+		Host: "a.example.com", Requests: 4, CachedIPs: 2, EjectedIPs: 1, OpenConns: a.OpenConns,
+	}, a)
+	assert.Equal(t, HostSnapshot{Host: "b.example.com", Requests: 2, Errors: 2}, b)
+	assert.Equal(t, "blocking.example.com", blocked.Host)
+	assert.Equal(t, int64(1), blocked.InFlight)
+	assert.Equal(t, int64(1), blocked.OpenConns)
+
+	assert.Equal(t, uint64(7), snap.Requests)
+	assert.Equal(t, uint64(2), snap.Errors)
+	assert.Equal(t, int64(1), snap.InFlight)
+	assert.Equal(t, a.OpenConns+1, snap.OpenConns)
+	assert.Equal(t, 2*snap.OpenConns, snap.Goroutines)
+	assert.Equal(t, 2, snap.EjectedIPs)
+	assert.Equal(t, 2, snap.CachedHosts)
+	assert.Equal(t, 4, snap.CachedIPs)
+	assert.Equal(t, 2, snap.Transports)
+	assert.Equal(t, uint64(5), snap.DNSCacheHits+snap.DNSCacheMisses)
+}
@@ -0,0 +1,44 @@
+package s3transport
+
+import (
+	"math/rand"
+	"net"
+)
+
+// WithColdStartSpread makes T send the first n requests to each host to distinct IPs, in a
+// random order, rather than choosing each independently, so that a burst of requests to a
+// cold host warms connections to as many IPs as possible. If the host has fewer than n IPs,
+// they're reused in the same order. It takes precedence over the other balancers, but not
+// over exclusions, subnet preferences, or sticky retries; a request whose assigned IP isn't
+// a candidate is balanced as usual, and still counts toward n.
+func WithColdStartSpread(n int) Option {
+	return func(t *T) { t.coldStartSpread = n }
+}
+
+// chooseColdStart returns the IP assigned to the next of host's first t.coldStartSpread
+// requests, if it's among candidates. Otherwise, it returns nil.
+func (t *T) chooseColdStart(host string, candidates []net.IP) net.IP {
+	if t.coldStartSpread <= 0 {
+		return nil
+	}
+	s := t.host(host)
+	s.spreadMu.Lock()
+	defer s.spreadMu.Unlock()
+	if s.spreadPicks >= t.coldStartSpread {
+		return nil
+	}
+	if s.spreadOrder == nil {
+		s.spreadOrder = append([]net.IP(nil), candidates...)
+		rand.Shuffle(len(s.spreadOrder), func(i, j int) {
+			s.spreadOrder[i], s.spreadOrder[j] = s.spreadOrder[j], s.spreadOrder[i]
+		})
+	}
+	ip := s.spreadOrder[s.spreadPicks%len(s.spreadOrder)]
+	s.spreadPicks++
+	for _, candidate := range candidates {
+		if candidate.Equal(ip) {
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package s3transport
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestColdStartSpread(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	const n = 5
+	rt := newTestT(srv.factory, testIPs(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), WithColdStartSpread(n))
+	defer rt.Close()
+
+	var (
+		wg        sync.WaitGroup
+		histories [n]History
+	)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(h *History) {
+			defer wg.Done()
+			_, err := get(RecordAttempts(context.Background(), h), rt, "http://s3.example.com/")
+			assert.NoError(t, err)
+		}(&histories[i])
+	}
+	wg.Wait()
+	ips := map[string]bool{}
+	for i := range histories {
+		attempts := histories[i].Attempts()
+		require.Len(t, attempts, 1)
+		ips[attempts[0].IP.String()] = true
+	}
+	assert.Len(t, ips, n, "the cold requests went to distinct IPs")
+}
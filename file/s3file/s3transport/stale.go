@@ -0,0 +1,53 @@
+package s3transport
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/grailbio/base/log"
+)
+
+// WithStaleWhileError makes T, when resolving a host fails, use the host's last successfully
+// resolved IPs instead, if they expired from the DNS cache less than grace ago. Each such use
+// is counted in Stats.StaleDNSLookups, and hosts starting and stopping using stale IPs are
+// logged. Fresh lookups are still attempted for every
+// request, so fresh results are used as soon as the resolver recovers.
+func WithStaleWhileError(grace time.Duration) Option {
+	return func(t *T) { t.staleGrace = grace }
+}
+
+// staleIPs returns host's last known good IPs, after resolving it failed with err, or nil if
+// they're too old.
+func (t *T) staleIPs(host string, err error) []net.IP {
+	ips := t.resolver.lastGood(host, t.staleGrace)
+	if ips == nil {
+		return nil
+	}
+	atomic.AddUint64(&t.stats.staleDNSLookups, 1)
+	t.staleMu.Lock()
+	started := !t.staleHosts[host]
+	if started {
+		if t.staleHosts == nil {
+			t.staleHosts = map[string]bool{}
+		}
+		t.staleHosts[host] = true
+	}
+	t.staleMu.Unlock()
+	if started {
+		// Logged once, rather than for each of the requests during an outage.
+		log.Printf("s3transport: %s: using stale ips after lookup error: %v", host, err)
+	}
+	return ips
+}
+
+// freshIPs records that resolving host succeeded, so it's no longer using stale IPs.
+func (t *T) freshIPs(host string) {
+	t.staleMu.Lock()
+	stopped := t.staleHosts[host]
+	delete(t.staleHosts, host)
+	t.staleMu.Unlock()
+	if stopped {
+		log.Printf("s3transport: %s: lookups succeeded; no longer using stale ips", host)
+	}
+}
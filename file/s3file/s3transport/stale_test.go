@@ -0,0 +1,92 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleWhileError(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := New(srv.factory, WithStaleWhileError(time.Minute))
+	defer rt.Close()
+	var (
+		now     = time.Now()
+		failing bool
+	)
+	rt.resolver = newResolver(func(string) ([]net.IP, error) {
+		if failing {
+			return nil, errors.New("dns outage")
+		}
+		return testIPs(1), nil
+	}, func() time.Time { return now })
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+
+	failing = true
+	now = now.Add(dnsCacheTime + time.Second)
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err, "the stale IPs are used within the grace window")
+	assert.EqualValues(t, 1, rt.Stats().StaleDNSLookups)
+
+	now = now.Add(time.Minute)
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	assert.Error(t, err, "but not after it")
+	assert.EqualValues(t, 1, rt.Stats().StaleDNSLookups)
+
+	failing = false
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, rt.Stats().DNSCacheMisses, "fresh lookups resume")
+}
+
+func TestStaleWhileErrorLogsOnce(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := New(srv.factory, WithStaleWhileError(time.Minute))
+	defer rt.Close()
+	var (
+		now     = time.Now()
+		failing bool
+	)
+	rt.resolver = newResolver(func(string) ([]net.IP, error) {
+		if failing {
+			return nil, errors.New("dns outage")
+		}
+		return testIPs(1), nil
+	}, func() time.Time { return now })
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+
+	var out capturingOutputter
+	defer log.SetOutputter(log.SetOutputter(&out))
+	failing = true
+	now = now.Add(dnsCacheTime + time.Second)
+	for i := 0; i < 5; i++ {
+		_, err = get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	failing = false
+	_, err = get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+
+	var stale []string
+	for _, line := range out.lines {
+		if strings.Contains(line, "stale ips") {
+			stale = append(stale, line)
+		}
+	}
+	assert.EqualValues(t, 5, rt.Stats().StaleDNSLookups)
+	require.Len(t, stale, 2, "the outage is logged once, not for every request")
+	assert.Contains(t, stale[0], "using stale ips after lookup error: dns outage")
+	assert.Contains(t, stale[1], "no longer using stale ips")
+}
@@ -0,0 +1,79 @@
+package s3transport
+
+import "sync/atomic"
+
+// Stats are counts of T's activity since it was created.
+type Stats struct {
+	// DNSCacheHits counts requests whose host's IPs were served from the DNS cache, and
+	// DNSCacheMisses those that resolved the host afresh. Requests for IP literal hosts, and
+	// failed lookups, are not counted.
+	DNSCacheHits, DNSCacheMisses uint64
+	// StaleDNSLookups counts lookups that failed but were served stale IPs by
+	// WithStaleWhileError. They aren't cache hits or misses.
+	StaleDNSLookups uint64
+	// DroppedEvents counts events not sent because the channel given to WithEventChannel
+	// wasn't ready.
+	DroppedEvents uint64
+}
+
+// DNSCacheHitRatio returns the fraction of counted lookups that were DNS cache hits, or zero
+// if there were none. A low ratio suggests the cache's lifetime is short relative to the
+// request rate, or that DNS is volatile.
+func (s Stats) DNSCacheHitRatio() float64 {
+	total := s.DNSCacheHits + s.DNSCacheMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.DNSCacheHits) / float64(total)
+}
+
+// stats holds T's counters. Its fields are accessed atomically.
+type stats struct {
+	dnsCacheHits, dnsCacheMisses uint64
+	staleDNSLookups              uint64
+	droppedEvents                uint64
+}
+
+// Stats returns t's counts so far.
+func (t *T) Stats() Stats {
+	return Stats{
+		DNSCacheHits:    atomic.LoadUint64(&t.stats.dnsCacheHits),
+		DNSCacheMisses:  atomic.LoadUint64(&t.stats.dnsCacheMisses),
+		StaleDNSLookups: atomic.LoadUint64(&t.stats.staleDNSLookups),
+		DroppedEvents:   atomic.LoadUint64(&t.stats.droppedEvents),
+	}
+}
+
+// recordDNSLookup counts a lookup of host's IPs, which was served from the cache if cached.
+func (t *T) recordDNSLookup(host string, cached bool) {
+	if cached {
+		atomic.AddUint64(&t.stats.dnsCacheHits, 1)
+		t.observe(MetricDNSCacheHit, host, 1)
+	} else {
+		atomic.AddUint64(&t.stats.dnsCacheMisses, 1)
+		t.observe(MetricDNSCacheHit, host, 0)
+	}
+}
+
+// HostStats returns the number of requests RoundTrip has handled for host (a URL hostname,
+// without port), and the number of them that failed with an error, since t was created.
+// Responses are counted as successes regardless of their status.
+func (t *T) HostStats(host string) (requests, errors uint64) {
+	s := t.lookupHost(host)
+	if s == nil {
+		return 0, 0
+	}
+	return atomic.LoadUint64(&s.requests), atomic.LoadUint64(&s.errors)
+}
+
+// recordRequest counts a request for host that RoundTrip completed with err.
+func (t *T) recordRequest(host string, err error) {
+	if host == "" {
+		return
+	}
+	s := t.host(host)
+	atomic.AddUint64(&s.requests, 1)
+	if err != nil {
+		atomic.AddUint64(&s.errors, 1)
+	}
+}
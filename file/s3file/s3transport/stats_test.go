@@ -0,0 +1,77 @@
+package s3transport
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSCacheHitRatio(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var collector recordingCollector
+	rt := newTestT(srv.factory, testIPs(1), WithMetrics(&collector))
+	defer rt.Close()
+	assert.Zero(t, rt.Stats().DNSCacheHitRatio())
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, Stats{DNSCacheMisses: 1}, rt.Stats())
+
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := get(context.Background(), rt, "http://s3.example.com/")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	stats := rt.Stats()
+	assert.Equal(t, Stats{DNSCacheHits: n, DNSCacheMisses: 1}, stats)
+	assert.InDelta(t, float64(n)/(n+1), stats.DNSCacheHitRatio(), 1e-9)
+
+	observed := collector.Named(MetricDNSCacheHit)
+	require.Len(t, observed, n+1)
+	var sum float64
+	for _, m := range observed {
+		assert.Equal(t, "s3.example.com", m.Host)
+		sum += m.Value
+	}
+	assert.Equal(t, float64(n), sum)
+}
+
+func TestHostStats(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 5; i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+		if i%2 == 0 {
+			_, err = get(canceled, rt, "http://s3.example.com/")
+			require.Error(t, err)
+		}
+	}
+	_, err := get(context.Background(), rt, "http://other.example.com/")
+	require.NoError(t, err)
+
+	requests, errors := rt.HostStats("s3.example.com")
+	assert.EqualValues(t, 8, requests)
+	assert.EqualValues(t, 3, errors)
+	requests, errors = rt.HostStats("other.example.com")
+	assert.EqualValues(t, 1, requests)
+	assert.EqualValues(t, 0, errors)
+	requests, errors = rt.HostStats("unused.example.com")
+	assert.Zero(t, requests)
+	assert.Zero(t, errors)
+}
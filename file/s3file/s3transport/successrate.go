@@ -0,0 +1,49 @@
+package s3transport
+
+import (
+	"net"
+	"net/http"
+)
+
+// WithSuccessRateBalancing makes T choose among a host's IPs in proportion to their success
+// rates over their last window requests, where a request fails if it gets a transport error
+// or a retriable status (429 or 5xx). IPs without outcomes have a rate of 1, and rates never
+// fall below 1%, so failing IPs get less traffic, but aren't ejected, and can recover. Large
+// transfers balanced by WithBandwidthBalancing don't use the rates, and WithAdaptiveWeights
+// takes precedence if it's also set.
+func WithSuccessRateBalancing(window int) Option {
+	return func(t *T) { t.successWindow = window }
+}
+
+// chooseBySuccessRate picks one of host's candidates at random, weighted by their success
+// rates.
+func (t *T) chooseBySuccessRate(host string, candidates []net.IP) net.IP {
+	return t.chooseByScore(host, candidates, func(scores ipScores) float64 {
+		switch {
+		case !scores.rated:
+			return 1
+		case scores.successRate < minWeight:
+			return minWeight
+		}
+		return scores.successRate
+	})
+}
+
+// recordSuccess adds the outcome, resp or err, of req to host's ip's window of outcomes.
+func (t *T) recordSuccess(req *http.Request, host string, ip net.IP, resp *http.Response, err error) {
+	t.recordScore(req, host, ip, resp, err, func(is *ipState, success bool) {
+		if len(is.outcomes) < t.successWindow {
+			is.outcomes = append(is.outcomes, success)
+		} else {
+			is.outcomes[is.nextOutcome] = success
+			is.nextOutcome = (is.nextOutcome + 1) % len(is.outcomes)
+		}
+		var successes int
+		for _, s := range is.outcomes {
+			if s {
+				successes++
+			}
+		}
+		is.successRate, is.rated = float64(successes)/float64(len(is.outcomes)), true
+	})
+}
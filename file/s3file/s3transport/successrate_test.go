@@ -0,0 +1,50 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuccessRateBalancing(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	// Every other connection to 10.0.0.1 fails.
+	var dials int32
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if host, _, _ := net.SplitHostPort(addr); host == "10.0.0.1" && atomic.AddInt32(&dials, 1)%2 != 0 {
+				return nil, errors.New("connection refused")
+			}
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := newTestT(factory, testIPs(1, 2), WithSuccessRateBalancing(20), WithDisableKeepAlives())
+	defer rt.Close()
+
+	const n = 2000
+	var picks [2]int
+	for i := 0; i < n; i++ {
+		var h History
+		_, _ = get(RecordAttempts(context.Background(), &h), rt, "http://s3.example.com/")
+		if i < n/10 {
+			continue // Let the rates settle.
+		}
+		if h.Attempts()[0].IP.Equal(testIPs(1)[0]) {
+			picks[0]++
+		} else {
+			picks[1]++
+		}
+	}
+	// 10.0.0.1's success rate is 1/2, so it should get a third of the requests.
+	frac := float64(picks[0]) / float64(picks[0]+picks[1])
+	assert.True(t, frac > 0.25 && frac < 0.42, "the failing IP gets proportionally less: %v", picks)
+}
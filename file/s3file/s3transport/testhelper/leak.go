@@ -0,0 +1,82 @@
+// Package testhelper provides test utilities for users of s3transport.
+package testhelper
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	// settleTimeout bounds how long AssertNoLeaks waits for goroutines to exit after f returns.
+	// Connection and sweep goroutines exit asynchronously, so some slack is necessary.
+	settleTimeout = 5 * time.Second
+	settlePoll    = 10 * time.Millisecond
+)
+
+// AssertNoLeaks runs f, which should create, use, and Close an *s3transport.T, and then checks
+// that the number of goroutines returns to its value from before f was called and that no
+// pooled (idle) HTTP connections remain open. On failure it reports the leaked goroutines'
+// stacks through t.Errorf.
+//
+// AssertNoLeaks counts goroutines process-wide, so it should not be used in parallel tests.
+func AssertNoLeaks(t testing.TB, f func()) {
+	t.Helper()
+	before := takeSnapshot()
+	f()
+	deadline := time.Now().Add(settleTimeout)
+	var after snapshot
+	for {
+		after = takeSnapshot()
+		if after.total <= before.total && after.conns <= before.conns {
+			return
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(settlePoll)
+	}
+	if after.conns > before.conns {
+		t.Errorf("s3transport: %d idle connection goroutine(s) leaked", after.conns-before.conns)
+	}
+	if after.total > before.total {
+		t.Errorf("s3transport: %d goroutine(s) leaked (before: %d, after: %d):\n%s",
+			after.total-before.total, before.total, after.total, after.stacks)
+	}
+}
+
+type snapshot struct {
+	// total is the number of goroutines, excluding the caller's.
+	total int
+	// conns counts goroutines that belong to net/http's persistent connections.
+	conns int
+	// stacks is the goroutine dump the counts are derived from.
+	stacks string
+}
+
+func takeSnapshot() snapshot {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var s snapshot
+	// The first goroutine in the dump is the current one.
+	for i, g := range bytes.Split(buf, []byte("\n\n")) {
+		if i == 0 {
+			continue
+		}
+		s.total++
+		if strings.Contains(string(g), "net/http.(*persistConn)") {
+			s.conns++
+		}
+	}
+	s.stacks = string(buf)
+	return s
+}
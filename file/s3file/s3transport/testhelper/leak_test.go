@@ -0,0 +1,65 @@
+package testhelper
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/file/s3file/s3transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTB captures failures instead of failing the real test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertNoLeaks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	factory := func() *http.Transport {
+		return &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, srv.Listener.Addr().String())
+			},
+		}
+	}
+
+	AssertNoLeaks(t, func() {
+		rt := s3transport.New(factory)
+		defer rt.Close()
+		resp, err := (&http.Client{Transport: rt}).Get("http://localhost/")
+		require.NoError(t, err)
+		_, _ = ioutil.ReadAll(resp.Body)
+		require.NoError(t, resp.Body.Close())
+	})
+}
+
+func TestAssertNoLeaksDetectsLeak(t *testing.T) {
+	defer func(old time.Duration) { settleTimeout = old }(settleTimeout)
+	settleTimeout = 100 * time.Millisecond
+
+	var leaked *s3transport.T
+	rec := recordingTB{TB: t}
+	AssertNoLeaks(&rec, func() {
+		leaked = s3transport.New(http.DefaultTransport.(*http.Transport).Clone)
+	})
+	leaked.Close()
+	assert.NotEmpty(t, rec.errors, "expected the unclosed transport's sweep goroutine to be reported")
+}
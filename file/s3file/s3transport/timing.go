@@ -0,0 +1,35 @@
+package s3transport
+
+import (
+	"context"
+	"time"
+)
+
+// Timing breaks down where a request's time went. Phases that didn't happen (for example,
+// connecting, when a pooled connection was reused) are zero. Per-attempt phases describe the
+// attempt whose result RoundTrip returned.
+type Timing struct {
+	// DNS is the time to resolve the request's host.
+	DNS time.Duration
+	// Connect is the time to establish the TCP connection.
+	Connect time.Duration
+	// TLS is the time of the TLS handshake.
+	TLS time.Duration
+	// FirstByte is the time from the start of the attempt to the first response byte.
+	FirstByte time.Duration
+	// Total is the time RoundTrip took, until response headers (or an error) were received.
+	Total time.Duration
+}
+
+type timingKey struct{}
+
+// RecordTiming returns a context that makes T fill tm for a request using the context. tm is
+// valid after RoundTrip returns. A context (and tm) should be used for only one request.
+func RecordTiming(ctx context.Context, tm *Timing) context.Context {
+	return context.WithValue(ctx, timingKey{}, tm)
+}
+
+func timingFromContext(ctx context.Context) *Timing {
+	tm, _ := ctx.Value(timingKey{}).(*Timing)
+	return tm
+}
@@ -0,0 +1,50 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTiming(t *testing.T) {
+	srv := httptest.NewTLSServer(okHandler())
+	defer srv.Close()
+	factory := func() *http.Transport {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, srv.Listener.Addr().String())
+		}
+		return transport
+	}
+	rt := New(factory)
+	defer rt.Close()
+	rt.resolver = newResolver(func(string) ([]net.IP, error) {
+		time.Sleep(5 * time.Millisecond)
+		return testIPs(1), nil
+	}, time.Now)
+
+	var cold Timing
+	// httptest's certificate is valid for example.com.
+	_, err := get(RecordTiming(context.Background(), &cold), rt, "https://example.com/")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, int64(cold.DNS), int64(5*time.Millisecond))
+	assert.Greater(t, int64(cold.Connect), int64(0))
+	assert.Greater(t, int64(cold.TLS), int64(0))
+	assert.GreaterOrEqual(t, int64(cold.FirstByte), int64(cold.Connect+cold.TLS))
+	assert.GreaterOrEqual(t, int64(cold.Total), int64(cold.DNS+cold.FirstByte))
+
+	var warm Timing
+	_, err = get(RecordTiming(context.Background(), &warm), rt, "https://example.com/")
+	require.NoError(t, err)
+	assert.Zero(t, warm.Connect, "pooled connection should be reused")
+	assert.Zero(t, warm.TLS)
+	assert.Greater(t, int64(warm.FirstByte), int64(0))
+	assert.GreaterOrEqual(t, int64(warm.Total), int64(warm.FirstByte))
+}
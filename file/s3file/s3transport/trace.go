@@ -0,0 +1,89 @@
+package s3transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// attemptTrace collects the httptrace events of one attempt. Some events are delivered on
+// net/http's goroutines, so accesses must hold mu.
+type attemptTrace struct {
+	mu      sync.Mutex
+	getConn time.Time
+	// connectStart, etc., are the times of the corresponding events, or zero.
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	firstByte                 time.Time
+	// reused is whether any connection the attempt used was reused from the pool, and
+	// connected whether the attempt got a connection at all.
+	reused, connected bool
+}
+
+// withAttemptTrace returns a context that records ctx's request's events in at and reports
+// metrics for host's ip. Any trace already in ctx still receives events.
+func (t *T) withAttemptTrace(ctx context.Context, host string, ip net.IP, at *attemptTrace) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			at.mu.Lock()
+			at.getConn = time.Now()
+			at.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			at.mu.Lock()
+			wait := time.Since(at.getConn)
+			at.reused = at.reused || info.Reused
+			at.connected = true
+			at.mu.Unlock()
+			markConnected(ctx)
+			t.observe(MetricConnWait, host, wait.Seconds())
+			t.recordConnRequest(host, ip)
+		},
+		ConnectStart:      func(string, string) { at.record(&at.connectStart) },
+		ConnectDone:       func(string, string, error) { at.record(&at.connectDone) },
+		TLSHandshakeStart: func() { at.record(&at.tlsStart) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			at.record(&at.tlsDone)
+			t.tlsHandshakeDone(host, ip, err)
+		},
+		GotFirstResponseByte: func() { at.record(&at.firstByte) },
+	})
+}
+
+// record sets *event, which is one of at's fields, to the current time.
+func (at *attemptTrace) record(event *time.Time) {
+	at.mu.Lock()
+	*event = time.Now()
+	at.mu.Unlock()
+}
+
+// fillTiming sets tm's per-attempt fields from at, for an attempt that started at start.
+func (at *attemptTrace) fillTiming(tm *Timing, start time.Time) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	tm.Connect, tm.TLS, tm.FirstByte = 0, 0, 0
+	if !at.connectDone.IsZero() {
+		tm.Connect = at.connectDone.Sub(at.connectStart)
+	}
+	if !at.tlsDone.IsZero() {
+		tm.TLS = at.tlsDone.Sub(at.tlsStart)
+	}
+	if !at.firstByte.IsZero() {
+		tm.FirstByte = at.firstByte.Sub(start)
+	}
+}
+
+func (at *attemptTrace) wasReused() bool {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	return at.reused
+}
+
+func (at *attemptTrace) wasConnected() bool {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	return at.connected
+}
@@ -2,22 +2,164 @@ package s3transport
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/grailbio/base/retry"
+	"golang.org/x/time/rate"
 )
 
 // T is an http.RoundTripper specialized for S3. See https://github.com/aws/aws-sdk-go/issues/3739.
 type T struct {
-	factory func() *http.Transport
+	// stats is first, for the alignment its atomically accessed fields require.
+	stats stats
+	// roundTrips counts RoundTrip calls in progress, and shutDown is set by Shutdown. They're
+	// accessed atomically.
+	roundTrips int64
+	shutDown   int32
+
+	factory  func() *http.Transport
+	resolver *resolver
+	// name labels t's logs, metrics, and attempts. See WithName.
+	name string
+
+	// strictExclusion makes RoundTrip fail, rather than ignore exclusions, if ExcludeIPs
+	// excludes every candidate.
+	strictExclusion bool
+	metrics         MetricsCollector
+	// echConfigList, if not nil, is set as the per-host transports' Encrypted Client Hello
+	// config list.
+	echConfigList []byte
+	// maxHosts, if positive, bounds the number of hosts whose IPs and transports are retained.
+	maxHosts int
+	// maxIPsPerHost, if positive, bounds the number of IPs retained for each host.
+	maxIPsPerHost int
+	// rotationPolicy applies when a host's IPs are all replaced; see WithRotationPolicy.
+	rotationPolicy RotationPolicy
+	// probeTimeout, if positive, enables WithReachabilityProbe, whose probes dial with
+	// probeDial.
+	probeTimeout time.Duration
+	probeDial    dialFunc
+	// preferredSubnets are preferred over other IPs, if any candidates are within them.
+	preferredSubnets []net.IPNet
+	// debugFanOut sends idempotent requests to every candidate IP; see WithDebugFanOut.
+	debugFanOut bool
+	// hostsMap is the map given to WithHostsMap.
+	hostsMap map[string][]net.IP
+	// connectProxy, if not empty, is the proxy address given to WithConnectProxy.
+	connectProxy string
+	// connectDeadline, if positive, enables WithConnectDeadline.
+	connectDeadline time.Duration
+	// responseHeaderTimeout, if positive, overrides the factory's ResponseHeaderTimeout.
+	responseHeaderTimeout time.Duration
+	// dialLimiter, if not nil, limits the rate of dials; see WithDialRateLimit.
+	dialLimiter *rate.Limiter
+	// disableKeepAlives overrides the factory's DisableKeepAlives; see WithDisableKeepAlives.
+	disableKeepAlives bool
+	// scheduler, if not nil, runs background work instead of per-T goroutines.
+	scheduler *Scheduler
+	// retryPolicy, if not nil, enables retries of failed attempts.
+	retryPolicy retry.Policy
+	// retryDeadline, if positive, bounds the total time of a request's attempts.
+	retryDeadline time.Duration
+	// validateFactory and normalizeFactory are set by WithFactoryValidation.
+	validateFactory, normalizeFactory bool
+	// successWindow, if positive, enables WithSuccessRateBalancing over that many outcomes.
+	successWindow int
+	// negativeTTL, if positive, is how long negative caches failed lookups.
+	negativeTTL time.Duration
+	negative    negativeCache
+	// respectTTL enables WithRespectDNSTTL. minTTL and maxTTL, if positive, bound the TTLs
+	// it respects.
+	respectTTL     bool
+	minTTL, maxTTL time.Duration
+	// staleGrace, if positive, enables WithStaleWhileError. staleHosts are the hosts using
+	// stale IPs.
+	staleGrace time.Duration
+	staleMu    sync.Mutex
+	staleHosts map[string]bool
+	// accessLog, if not nil, receives a record of each request. See WithAccessLog.
+	accessLog func(AccessRecord)
+	// lastGoodAffinity enables WithLastGoodIPAffinity.
+	lastGoodAffinity bool
+	// dialNetwork, if not empty, replaces the network of dials. See WithDialNetwork.
+	dialNetwork string
+	// coldStartSpread, if positive, is the number of a host's first requests that are spread
+	// across distinct IPs.
+	coldStartSpread int
+	// retryStickiness decides which IPs retries go to. See WithRetryStickiness.
+	retryStickiness RetryStickiness
+	// slowestPerHost is the number of slowest requests to retain per host.
+	slowestPerHost int
+	// staleConnRetry resends requests that fail on reused connections; see WithStaleConnRetry.
+	staleConnRetry bool
+	// bandwidthBalancing, bandwidthMeasureMin, and bandwidthLargeMin configure
+	// WithBandwidthBalancing.
+	bandwidthBalancing                     bool
+	bandwidthMeasureMin, bandwidthLargeMin int64
+	// onEvict is called when a host's IPs all expire; see WithOnEvict.
+	onEvict func(host string, ips []net.IP)
+	// middleware wraps route to make dispatch, which RoundTrip calls. See WithMiddleware.
+	middleware []func(next http.RoundTripper) http.RoundTripper
+	dispatch   http.RoundTripper
+	// serverNameOverrides is host -> TLS server name. See WithServerNameOverride.
+	serverNameOverrides map[string]string
+	// serverNameForIP, if not nil, overrides TLS server names; see WithServerNameForIP.
+	serverNameForIP func(host string, ip net.IP) string
+	// tlsVerifyHook, if not nil, is called after TLS handshakes; see WithTLSVerifyHook.
+	tlsVerifyHook func(host string, ip net.IP, serverName string, err error)
+	// events, if not nil, receives Events; see WithEventChannel.
+	events chan<- Event
+	// methodRoutes maps methods to the hosts to send them to; see WithMethodRouting.
+	methodRoutes map[string]string
+	// regionalEndpoint replaces S3's global endpoint; see WithRegionalEndpoint.
+	regionalEndpoint string
+	// weightDecay and weightRecovery, if weightDecay is positive, adapt IPs' weights; see
+	// WithAdaptiveWeights.
+	weightDecay, weightRecovery float64
+	// scoreRefresh, if positive, is how often balancers' snapshots of IP scores are
+	// refreshed; see WithScoreRefreshInterval.
+	scoreRefresh time.Duration
+	// maxRedirects, if positive, enables WithRegionRedirects. redirects maps hosts to the
+	// hosts they were permanently redirected to.
+	maxRedirects int
+	redirectsMu  sync.Mutex
+	redirects    map[string]redirect
+	// fallback is set by WithFallback.
+	fallback bool
+	// fault is injected into a fraction faultRate of attempts; see WithFaultInjection.
+	fault     Fault
+	faultRate float64
+	faults    faultInjector
+	// balancer picks among candidates when no scoring balancer applies. See WithBalancer.
+	balancer Balancer
 
 	hostRTsMu sync.Mutex
-	hostRTs   map[string]http.RoundTripper
+	hostRTs   map[hostKey]http.RoundTripper
+	// fallbackRT is created on first use by fallbackRoundTripper.
+	fallbackRT http.RoundTripper
+
+	hostsMu sync.Mutex
+	hosts   map[string]*hostState
+
+	// paused is the set of hosts paused with Pause. It's separate from hosts so that eviction
+	// doesn't resume hosts.
+	pausedMu sync.RWMutex
+	paused   map[string]bool
 
 	hostIPs *expiringMap
+	// cacheFile, if not empty, enables WithPersistentCacheFile.
+	cacheFile string
+	// ipCache, if not nil, replaces hostIPs.
+	ipCache IPCache
+
+	closeOnce sync.Once
 }
 
 var (
@@ -44,47 +186,448 @@ var (
 
 // New constructs *T using factory to create internal transports. Each call to factory()
 // must return a separate http.Transport and they must not share TLSClientConfig.
-func New(factory func() *http.Transport) *T {
-	return &T{
-		factory: factory,
-		hostRTs: map[string]http.RoundTripper{},
-		hostIPs: newExpiringMap(runPeriodicForever(), time.Now),
+func New(factory func() *http.Transport, opts ...Option) *T {
+	t := &T{
+		factory:   factory,
+		resolver:  defaultResolver,
+		hostRTs:   map[hostKey]http.RoundTripper{},
+		hosts:     map[string]*hostState{},
+		paused:    map[string]bool{},
+		redirects: map[string]redirect{},
+		balancer:  RandomBalancer,
+		faults:    faultInjector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))},
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
+	if t.validateFactory {
+		t.checkFactory()
+	}
+	if t.probeTimeout > 0 && t.factory != nil {
+		t.probeDial = t.limitDial(transportDial(t.factory()))
+	}
+	if t.respectTTL {
+		t.resolver = t.resolver.withCacheTime(t.cacheTime)
+	}
+	if t.cacheFile != "" {
+		// Imported IPs seed the DNS cache, which mustn't be the one shared with other Ts.
+		t.resolver = t.resolver.withCacheTime(t.resolver.cacheTime)
+	}
+	runPeriodic := runPeriodicUntilDone()
+	if t.scheduler != nil {
+		runPeriodic = t.scheduler.runPeriodic
+	}
+	t.hostIPs = makeExpiringMap(time.Now)
+	t.hostIPs.name = t.name
+	t.hostIPs.maxHosts = t.maxHosts
+	t.hostIPs.maxIPsPerHost = t.maxIPsPerHost
+	t.hostIPs.onLRUEvict = t.evictHost
+	t.hostIPs.onExpire = t.onEvict
+	t.hostIPs.onExpireAge = t.observeExpiry
+	t.hostIPs.afterExpire = t.pruneState
+	t.hostIPs.start(runPeriodic)
+	if t.cacheFile != "" {
+		t.loadCacheFile()
+	}
+	t.dispatch = t.chain(roundTripperFunc(t.route))
+	return t
 }
 
+// RoundTrip implements http.RoundTripper. It resolves the request's host, picks one of the
+// host's IPs, and sends the request to that IP, verifying TLS against the original host.
+//
+// Requests whose URL host is an IP literal are sent as-is, without resolution or balancing,
+// and TLS verification uses the IP (as with http.Transport). Requests with an empty host fail,
+// as do those without a URL or whose scheme isn't http or https.
+// Plain http:// requests are rewritten the same way but don't touch TLS configuration.
 func (t *T) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := validateRequest(req); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	atomic.AddInt64(&t.roundTrips, 1)
+	defer atomic.AddInt64(&t.roundTrips, -1)
+	if atomic.LoadInt32(&t.shutDown) != 0 {
+		closeBody(req)
+		return nil, ErrShutdown
+	}
+	start := time.Now()
+	req, accessLog := t.startAccessLog(req, start)
+	// The request's own host is checked before routing may rewrite it; roundTrip checks the
+	// rewritten one.
+	var resp *http.Response
+	err := t.checkPaused(req.URL.Hostname())
+	if err != nil {
+		closeBody(req)
+	} else {
+		resp, err = t.dispatch.RoundTrip(req)
+	}
+	if tm := timingFromContext(req.Context()); tm != nil {
+		tm.Total = time.Since(start)
+	}
+	t.recordRequest(req.URL.Hostname(), err)
+	t.emitOutcome(EventCompleted, req.URL.Hostname(), resp, err, time.Since(start))
+	if accessLog != nil {
+		t.finishAccessLog(req.Context(), accessLog, resp, err)
+	}
+	return resp, err
+}
+
+// route implements RoundTrip, inside any middleware.
+func (t *T) route(req *http.Request) (*http.Response, error) {
+	req = t.redirected(t.regionalized(t.methodRouted(req)))
+	req, deadlineDone := t.withConnectDeadline(req)
+	var connected int32
+	if t.fallback {
+		req = withConnectedFlag(req, &connected)
+	}
+	resp, err := t.roundTrip(req, time.Now())
+	if t.maxRedirects > 0 {
+		resp, err = t.followRedirects(req, resp, err)
+	}
+	if err != nil && t.shouldFallBack(req, err, atomic.LoadInt32(&connected) != 0) {
+		resp, err = t.fallBack(req, err)
+	}
+	return deadlineDone(resp, err)
+}
+
+// roundTrip implements RoundTrip for a request that started at start.
+func (t *T) roundTrip(req *http.Request, start time.Time) (*http.Response, error) {
 	host := req.URL.Hostname()
+	if host == "" {
+		closeBody(req)
+		return nil, fmt.Errorf("s3transport: request url has no host: %q", req.URL)
+	}
+	if err := t.checkPaused(host); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	key := hostKey{
+		host: host, plaintext: req.URL.Scheme == "http", fresh: freshConnFromContext(req.Context()),
+	}
+	if net.ParseIP(host) != nil {
+		rt, err := t.hostRoundTripper(key)
+		if err != nil {
+			closeBody(req)
+			return nil, err
+		}
+		recordAccessAttempt(req.Context(), net.ParseIP(host))
+		return rt.RoundTrip(req)
+	}
 
-	ips, err := defaultResolver.LookupIP(host)
+	ips, err := t.resolve(req, key, start)
 	if err != nil {
-		if req.Body != nil {
-			_ = req.Body.Close()
+		closeBody(req)
+		return nil, err
+	}
+	t.emit(Event{Kind: EventResolved, Host: host, IPs: ips})
+
+	rt, err := t.hostRoundTripper(key)
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	if t.retryPolicy != nil {
+		return t.roundTripWithRetries(rt, req, host, ips, start)
+	}
+	resp, _, err := t.attempt(rt, req, host, ips)
+	return resp, err
+}
+
+// resolve returns the IPs to choose from for req, whose transport is for key and which
+// started at start.
+func (t *T) resolve(req *http.Request, key hostKey, start time.Time) ([]net.IP, error) {
+	host := key.host
+	if r := resolverFromContext(req.Context()); r != nil {
+		ips, err := r.LookupIP(req.Context(), host)
+		if tm := timingFromContext(req.Context()); tm != nil {
+			tm.DNS = time.Since(start)
+		}
+		if err == nil && len(ips) == 0 {
+			err = fmt.Errorf("no ips for %s", host)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("s3transport: lookup ip: %w", err)
+		}
+		t.forgetFailure(host)
+		return ips, nil
+	}
+	if ips, ok := t.hostsMap[host]; ok && len(ips) > 0 {
+		return ips, nil
+	}
+	ips, cached, err := t.lookupIPCached(req.Context(), host)
+	if tm := timingFromContext(req.Context()); tm != nil {
+		tm.DNS = time.Since(start)
+	}
+	switch {
+	case err == nil:
+		t.recordDNSLookup(host, cached)
+		if t.staleGrace > 0 {
+			t.freshIPs(host)
 		}
+	case t.staleGrace > 0:
+		if ips = t.staleIPs(host, err); ips == nil {
+			return nil, fmt.Errorf("s3transport: lookup ip: %w", err)
+		}
+	default:
 		return nil, fmt.Errorf("s3transport: lookup ip: %w", err)
 	}
-	ips = t.hostIPs.AddAndGet(host, ips)
+	ttl := expireAfter
+	if t.respectTTL && err == nil {
+		// The IPs stay candidates only as long as the (bounded) TTL they were resolved with.
+		if remaining := t.resolver.remaining(host); remaining > 0 {
+			ttl = remaining
+		}
+	}
+	if t.probeTimeout > 0 {
+		ips = t.reachable(req, key, ips)
+	}
+	return t.cacheIPs(host, ips, ttl), nil
+}
+
+// attempt sends req once, to one of host's ips, using rt. If WithStaleConnRetry is set and
+// the attempt fails on a reused connection, it's resent once, to another IP if possible.
+func (t *T) attempt(
+	rt http.RoundTripper, req *http.Request, host string, ips []net.IP,
+) (*http.Response, attemptResult, error) {
+	resp, result, err := t.attemptOnce(rt, req, host, ips)
+	if err == nil || !result.reused || !t.staleConnRetry || !isReplayable(req) || req.Context().Err() != nil {
+		return resp, result, err
+	}
+	retryReq, rewindErr := rewound(ExcludeIPs(req.Context(), result.ip), req)
+	if rewindErr != nil {
+		return nil, result, err
+	}
+	return t.attemptOnce(rt, retryReq, host, ips)
+}
+
+// attemptResult describes how an attempt was sent.
+type attemptResult struct {
+	// ip is the IP the attempt went to.
+	ip net.IP
+	// reused is whether it was sent on a reused connection, and connected whether it got a
+	// connection at all.
+	reused, connected bool
+}
+
+// attemptOnce sends req to one of host's ips, using rt.
+func (t *T) attemptOnce(
+	rt http.RoundTripper, req *http.Request, host string, ips []net.IP,
+) (*http.Response, attemptResult, error) {
+	ip, candidates, err := t.pickIP(req, host, ips)
+	if err != nil {
+		closeBody(req)
+		return nil, attemptResult{}, err
+	}
+
+	var at attemptTrace
+	hostReq := req.Clone(t.withTunnelIP(t.withAttemptTrace(req.Context(), host, ip, &at), req, ip))
+	if hostReq.Host == "" {
+		hostReq.Host = req.URL.Host
+	}
+	hostReq.URL.Host = ipHost(ip, req.URL.Port())
+	t.emit(Event{Kind: EventPicked, Host: host, IP: ip})
+	t.reportWarmth(req.Context(), host, ip)
+	if rt, err = t.ipRoundTripper(rt, req, host, ip); err != nil {
+		closeBody(req)
+		return nil, attemptResult{ip: ip}, err
+	}
+
+	var resp *http.Response
+	if h := historyFromContext(req.Context()); t.debugFanOut && h != nil && isFanOutSafe(req) {
+		resp, err = t.fanOut(req.Context(), rt, hostReq, host, ip, candidates, h, &at)
+	} else {
+		resp, err = t.send(rt, hostReq, host, ip, &at)
+	}
+	return resp, attemptResult{ip: ip, reused: at.wasReused(), connected: at.wasConnected()}, err
+}
+
+// send sends hostReq, which is for host and addressed to ip, with rt, and records the attempt,
+// whose trace is at.
+func (t *T) send(
+	rt http.RoundTripper, hostReq *http.Request, host string, ip net.IP, at *attemptTrace,
+) (*http.Response, error) {
+	start := time.Now()
+	endInFlight := t.startInFlight(host, ip)
+	resp, err := t.roundTripWithFaults(rt, hostReq)
+	if err != nil {
+		endInFlight()
+	} else {
+		resp.Body = endInFlightWithBody(hostReq.Context(), resp, endInFlight)
+	}
+	a := t.newAttempt(hostReq, host, ip, start, resp, err)
+	a.Reused = at.wasReused()
+	if tm := timingFromContext(hostReq.Context()); tm != nil {
+		at.fillTiming(tm, start)
+	}
+	if h := historyFromContext(hostReq.Context()); h != nil {
+		h.add(a)
+	}
+	recordAccessAttempt(hostReq.Context(), ip)
+	t.recordSlowest(host, a)
+	if hostReq.Context().Err() == nil {
+		t.recordLatency(host, ip, a.Duration)
+	}
+	if t.weightDecay > 0 {
+		t.recordOutcome(hostReq, host, ip, resp, err)
+	}
+	if t.successWindow > 0 {
+		t.recordSuccess(hostReq, host, ip, resp, err)
+	}
+	if t.lastGoodAffinity {
+		t.recordAffinity(hostReq, host, ip, resp, err)
+	}
+	if t.bandwidthBalancing {
+		t.measureBandwidth(host, hostReq, resp, a)
+	}
+	return resp, err
+}
 
-	hostReq := req.Clone(req.Context())
-	hostReq.Host = host
-	// TODO: Consider other load balancing strategies.
-	hostReq.URL.Host = ips[rand.Intn(len(ips))].String()
+// ipHost returns the URL host for connecting to ip at the given (possibly empty) port.
+func ipHost(ip net.IP, port string) string {
+	if port != "" {
+		return net.JoinHostPort(ip.String(), port)
+	}
+	if ip.To4() == nil {
+		return "[" + ip.String() + "]"
+	}
+	return ip.String()
+}
 
-	return t.hostRoundTripper(host).RoundTrip(hostReq)
+// hostKey identifies a per-host transport. Plaintext (http://) requests use separate
+// transports because they don't get TLS configuration.
+type hostKey struct {
+	host      string
+	plaintext bool
+	// serverName, if not empty, replaces host as the TLS server name. See
+	// WithServerNameForIP.
+	serverName string
+	// fresh transports don't pool connections. See UseFreshConnection.
+	fresh bool
 }
 
-func (t *T) hostRoundTripper(host string) http.RoundTripper {
+func (t *T) hostRoundTripper(key hostKey) (http.RoundTripper, error) {
 	t.hostRTsMu.Lock()
 	defer t.hostRTsMu.Unlock()
-	if rt, ok := t.hostRTs[host]; ok {
-		return rt
+	if rt, ok := t.hostRTs[key]; ok {
+		return rt, nil
+	}
+	switch t.dialNetwork {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("s3transport: unsupported dial network %q", t.dialNetwork)
 	}
 	transport := t.factory()
-	// We modify request URL to contain an IP, but server certificates list hostnames, so we
-	// configure our client to check against original hostname.
-	if transport.TLSClientConfig == nil {
-		transport.TLSClientConfig = &tls.Config{}
-	}
-	transport.TLSClientConfig.ServerName = host
-	t.hostRTs[host] = transport
-	return transport
+	if t.normalizeFactory {
+		normalizeTransport(transport)
+	}
+	if t.responseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = t.responseHeaderTimeout
+	}
+	if t.disableKeepAlives || key.fresh {
+		transport.DisableKeepAlives = true
+	}
+	t.instrumentDial(key.host, transport)
+	if err := t.configureProxy(transport); err != nil {
+		return nil, err
+	}
+	if !key.plaintext {
+		// We modify request URL to contain an IP, but server certificates list hostnames, so we
+		// configure our client to check against original hostname. IP literal hosts aren't
+		// rewritten, so the default verification against the IP is already right.
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		switch name, overridden := t.serverNameOverrides[key.host]; {
+		case key.serverName != "":
+			transport.TLSClientConfig.ServerName = key.serverName
+		case overridden:
+			transport.TLSClientConfig.ServerName = name
+		case net.ParseIP(key.host) == nil:
+			transport.TLSClientConfig.ServerName = key.host
+		}
+		if t.echConfigList != nil {
+			if err := setECHConfigList(transport.TLSClientConfig, t.echConfigList); err != nil {
+				return nil, err
+			}
+		}
+	}
+	t.hostRTs[key] = transport
+	return transport, nil
+}
+
+// CacheSize returns the number of hosts t is retaining IPs for and the total number of
+// retained IPs across those hosts. It reports zero if WithIPCache is used.
+func (t *T) CacheSize() (hosts, ips int) {
+	return t.hostIPs.Size()
+}
+
+// evictHost discards host's transports, closing their idle connections, and host's state.
+// In-flight requests on the transports are unaffected.
+func (t *T) evictHost(host string) {
+	var rts []http.RoundTripper
+	t.hostRTsMu.Lock()
+	for key, rt := range t.hostRTs {
+		if key.host == host {
+			rts = append(rts, rt)
+			delete(t.hostRTs, key)
+		}
+	}
+	t.hostRTsMu.Unlock()
+	t.hostsMu.Lock()
+	delete(t.hosts, host)
+	t.hostsMu.Unlock()
+	for _, rt := range rts {
+		if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
+// CloseIdleConnections closes idle connections in all the per-host transports. It does not
+// interrupt in-flight requests.
+func (t *T) CloseIdleConnections() {
+	t.hostRTsMu.Lock()
+	defer t.hostRTsMu.Unlock()
+	for _, rt := range t.hostRTs {
+		if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+	}
+	if c, ok := t.fallbackRT.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// Close stops t's background goroutines, closes idle connections, and, with
+// WithPersistentCacheFile, saves t's DNS cache. t should not be used after Close, and Close
+// must not be called on Default, which is shared by the whole process. Subsequent calls to
+// Close are no-ops.
+func (t *T) Close() {
+	t.closeOnce.Do(func() {
+		t.hostIPs.Close()
+		t.CloseIdleConnections()
+		if t.cacheFile != "" {
+			t.saveCacheFile()
+		}
+	})
+}
+
+// validateRequest returns an error if req is too malformed to route.
+func validateRequest(req *http.Request) error {
+	switch {
+	case req.URL == nil:
+		return errors.New("s3transport: request has no url")
+	case req.URL.Scheme == "":
+		return fmt.Errorf("s3transport: request url has no scheme: %q", req.URL)
+	case req.URL.Scheme != "http" && req.URL.Scheme != "https":
+		return fmt.Errorf("s3transport: unsupported protocol scheme %q", req.URL.Scheme)
+	}
+	return nil
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
 }
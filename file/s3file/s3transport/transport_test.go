@@ -1,2 +1,478 @@
-// s3transport is exercised in s3file's *AWS integration tests.
+// s3transport is also exercised in s3file's *AWS integration tests.
 package s3transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer is an HTTP server that receives all of a T's connections, regardless of the IP
+// the T selected. It records the IPs that were dialed.
+type testServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	dialed []string
+}
+
+// newTestServer starts a testServer. Callers must Close it.
+func newTestServer(handler http.Handler) *testServer {
+	return &testServer{Server: httptest.NewServer(handler)}
+}
+
+// factory is a T factory whose transports dial s.
+func (s *testServer) factory() *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			s.mu.Lock()
+			s.dialed = append(s.dialed, host)
+			s.mu.Unlock()
+			var d net.Dialer
+			return d.DialContext(ctx, network, s.Listener.Addr().String())
+		},
+	}
+}
+
+func (s *testServer) Dialed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.dialed...)
+}
+
+// newTestT returns a T that resolves every host to ips and uses factory, which is typically
+// a testServer's. Callers must Close it.
+func newTestT(factory func() *http.Transport, ips []net.IP, opts ...Option) *T {
+	rt := New(factory, opts...)
+	rt.resolver = newResolver(func(string) ([]net.IP, error) { return ips, nil }, time.Now)
+	return rt
+}
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+}
+
+// get issues a GET for url with ctx through rt and reads the whole response.
+func get(ctx context.Context, rt http.RoundTripper, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = ioutil.ReadAll(resp.Body)
+	return resp, resp.Body.Close()
+}
+
+func testIPs(is ...byte) (ret []net.IP) {
+	for _, i := range is {
+		ret = append(ret, net.IP{10, 0, 0, i})
+	}
+	return
+}
+
+func TestExcludeIPs(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1, 2, 3))
+	defer rt.Close()
+	ctx := ExcludeIPs(context.Background(), testIPs(2)...)
+	for i := 0; i < 50; i++ {
+		_, err := get(ctx, rt, "http://s3.example.com/")
+		require.NoError(t, err)
+		rt.CloseIdleConnections() // Force a new dial so every pick is observed.
+	}
+	dialed := srv.Dialed()
+	assert.Len(t, dialed, 50)
+	assert.NotContains(t, dialed, "10.0.0.2")
+	assert.Contains(t, dialed, "10.0.0.1")
+	assert.Contains(t, dialed, "10.0.0.3")
+}
+
+func TestExcludeAllIPs(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	ctx := ExcludeIPs(context.Background(), testIPs(1, 2)...)
+
+	rt := newTestT(srv.factory, testIPs(1, 2))
+	defer rt.Close()
+	_, err := get(ctx, rt, "http://s3.example.com/")
+	assert.NoError(t, err, "by default, exclusion of all ips falls back to all ips")
+
+	strict := newTestT(srv.factory, testIPs(1, 2), WithStrictExclusion())
+	defer strict.Close()
+	_, err = get(ctx, strict, "http://s3.example.com/")
+	assert.Equal(t, ErrAllIPsExcluded, err)
+}
+
+func TestMaxHosts(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1, 2), WithMaxHosts(3))
+	defer rt.Close()
+	for i := 0; i < 10; i++ {
+		_, err := get(context.Background(), rt, fmt.Sprintf("http://s3-%d.example.com/", i))
+		require.NoError(t, err)
+		hosts, ips := rt.CacheSize()
+		assert.LessOrEqual(t, hosts, 3)
+		assert.Equal(t, 2*hosts, ips)
+		rt.hostRTsMu.Lock()
+		assert.LessOrEqual(t, len(rt.hostRTs), 3)
+		rt.hostRTsMu.Unlock()
+	}
+}
+
+func TestPreferredSubnets(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	_, local, err := net.ParseCIDR("10.0.1.0/24")
+	require.NoError(t, err)
+	ips := []net.IP{{10, 0, 0, 1}, {10, 0, 1, 1}, {10, 0, 1, 2}, {10, 0, 2, 1}}
+	rt := newTestT(srv.factory, ips, WithPreferredSubnets([]net.IPNet{*local}))
+	defer rt.Close()
+	for i := 0; i < 30; i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+		rt.CloseIdleConnections()
+	}
+	dialed := srv.Dialed()
+	assert.Len(t, dialed, 30)
+	for _, ip := range dialed {
+		assert.True(t, local.Contains(net.ParseIP(ip)), ip)
+	}
+
+	// When preferred IPs are excluded, requests fall back to the others.
+	ctx := ExcludeIPs(context.Background(), net.IP{10, 0, 1, 1}, net.IP{10, 0, 1, 2})
+	_, err = get(ctx, rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	dialed = srv.Dialed()
+	assert.False(t, local.Contains(net.ParseIP(dialed[len(dialed)-1])))
+}
+
+func TestEmptyHost(t *testing.T) {
+	rt := New(httpTransport.Clone)
+	defer rt.Close()
+	_, err := get(context.Background(), rt, "http:///bucket/key")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no host")
+}
+
+// closeRecorder is a request body that records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestMalformedRequests(t *testing.T) {
+	rt := New(httpTransport.Clone)
+	defer rt.Close()
+	for _, c := range []struct {
+		name, url, wantErr string
+	}{
+		{"nil url", "", "no url"},
+		{"no scheme", "//s3.example.com/bucket/key", "no scheme"},
+		{"unsupported scheme", "ftp://s3.example.com/bucket/key", "unsupported protocol scheme"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			body := &closeRecorder{Reader: strings.NewReader("data")}
+			req := &http.Request{Method: http.MethodPut, Header: http.Header{}, Body: body}
+			if c.url != "" {
+				var err error
+				req.URL, err = url.Parse(c.url)
+				require.NoError(t, err)
+			}
+			var (
+				resp *http.Response
+				err  error
+			)
+			require.NotPanics(t, func() { resp, err = rt.RoundTrip(req) })
+			assert.Nil(t, resp)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), c.wantErr)
+			assert.True(t, body.closed)
+		})
+	}
+}
+
+func TestIPLiteralHost(t *testing.T) {
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+	rt := New(srv.factory)
+	defer rt.Close()
+	rt.resolver = newResolver(func(host string) ([]net.IP, error) {
+		t.Errorf("unexpected lookup: %s", host)
+		return nil, fmt.Errorf("unexpected lookup")
+	}, time.Now)
+
+	for _, host := range []string{"10.0.0.7", "[fd00::7]"} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+host+":8080/bucket/key", nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, host+":8080", string(body), "request should be sent as-is")
+
+		hostRT, err := rt.hostRoundTripper(hostKey{host: req.URL.Hostname(), plaintext: true})
+		require.NoError(t, err)
+		assert.Nil(t, hostRT.(*http.Transport).TLSClientConfig)
+	}
+	assert.Equal(t, []string{"10.0.0.7", "fd00::7"}, srv.Dialed())
+}
+
+func TestPlaintext(t *testing.T) {
+	var gotHost string
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+	}))
+	defer srv.Close()
+	rt := newTestT(srv.factory, []net.IP{{10, 0, 0, 1}, net.ParseIP("fd00::1")})
+	defer rt.Close()
+
+	for _, url := range []string{"http://minio.internal:9000/bucket/key", "http://minio.internal/bucket/key"} {
+		for i := 0; i < 10; i++ {
+			_, err := get(context.Background(), rt, url)
+			require.NoError(t, err)
+			rt.CloseIdleConnections()
+		}
+	}
+	assert.Equal(t, "minio.internal", gotHost)
+	assert.ElementsMatch(t, []string{"10.0.0.1", "fd00::1"}, dedup(srv.Dialed()))
+
+	rt.hostRTsMu.Lock()
+	defer rt.hostRTsMu.Unlock()
+	require.Len(t, rt.hostRTs, 1)
+	for key, hostRT := range rt.hostRTs {
+		assert.True(t, key.plaintext)
+		assert.Nil(t, hostRT.(*http.Transport).TLSClientConfig, "no tls config should be set")
+	}
+}
+
+func TestRewritePreservesPort(t *testing.T) {
+	var gotHost string
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+	}))
+	defer srv.Close()
+	var dialedAddr string
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialedAddr = addr
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := newTestT(factory, []net.IP{net.ParseIP("fd00::1")})
+	defer rt.Close()
+	_, err := get(context.Background(), rt, "http://minio.internal:9000/bucket/key")
+	require.NoError(t, err)
+	assert.Equal(t, "[fd00::1]:9000", dialedAddr)
+	assert.Equal(t, "minio.internal:9000", gotHost)
+}
+
+func TestAttemptWireDetails(t *testing.T) {
+	srv := httptest.NewTLSServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(tlsFactory(srv, 1), testIPs(1))
+	defer rt.Close()
+	var h History
+	_, err := get(RecordAttempts(context.Background(), &h), rt,
+		"https://bucket.s3.example.com:8443/key?versionId=1")
+	require.NoError(t, err)
+	require.Len(t, h.Attempts(), 1)
+	a := h.Attempts()[0]
+	assert.Equal(t, "https://10.0.0.1:8443/key?versionId=1", a.URL)
+	assert.Equal(t, "bucket.s3.example.com:8443", a.Host)
+	assert.Equal(t, "bucket.s3.example.com", a.ServerName)
+
+	h = History{}
+	_, err = get(RecordAttempts(context.Background(), &h), rt, "https://bucket.s3.example.com:8443/key?"+
+		"X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKIA%2F20200101%2Fus-west-2&"+
+		"X-Amz-Signature=abc123&x-amz-security-token=secret&versionId=1")
+	require.NoError(t, err)
+	require.Len(t, h.Attempts(), 1)
+	assert.Equal(t, "https://10.0.0.1:8443/key?X-Amz-Algorithm=AWS4-HMAC-SHA256&"+
+		"X-Amz-Credential=REDACTED&X-Amz-Signature=REDACTED&x-amz-security-token=REDACTED&versionId=1",
+		h.Attempts()[0].URL, "presigned URLs' credentials are redacted")
+
+	plain := newTestServer(okHandler())
+	defer plain.Close()
+	rt = newTestT(plain.factory, []net.IP{net.ParseIP("fd00::1")})
+	defer rt.Close()
+	h = History{}
+	_, err = get(RecordAttempts(context.Background(), &h), rt, "http://minio.internal:9000/bucket/key")
+	require.NoError(t, err)
+	require.Len(t, h.Attempts(), 1)
+	a = h.Attempts()[0]
+	assert.Equal(t, "http://[fd00::1]:9000/bucket/key", a.URL)
+	assert.Equal(t, "minio.internal:9000", a.Host)
+	assert.Empty(t, a.ServerName)
+}
+
+func TestDisableKeepAlives(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+	for i := 0; i < 3; i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	assert.Len(t, srv.Dialed(), 1, "connections are reused by default")
+
+	srv2 := newTestServer(okHandler())
+	defer srv2.Close()
+	rt = newTestT(srv2.factory, testIPs(1), WithDisableKeepAlives())
+	defer rt.Close()
+	for i := 0; i < 3; i++ {
+		_, err := get(context.Background(), rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	assert.Len(t, srv2.Dialed(), 3, "each request dials")
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	srv := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer srv.Close()
+	defer close(unblock)
+	const timeout = 100 * time.Millisecond
+	rt := newTestT(srv.factory, testIPs(1), WithResponseHeaderTimeout(timeout))
+	defer rt.Close()
+
+	start := time.Now()
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	elapsed := time.Since(start)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout awaiting response headers")
+	assert.GreaterOrEqual(t, int64(elapsed), int64(timeout))
+	assert.Less(t, int64(elapsed), int64(10*timeout))
+}
+
+// failingConn is a net.Conn whose writes fail once kill is closed, like a pooled connection
+// whose peer silently went away.
+type failingConn struct {
+	net.Conn
+	kill <-chan struct{}
+}
+
+func (c failingConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.kill:
+		_ = c.Conn.Close()
+		return 0, syscall.ECONNRESET
+	default:
+		return c.Conn.Write(b)
+	}
+}
+
+func TestStaleConnRetry(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var (
+		mu   sync.Mutex
+		dead = map[string]bool{}
+		kill = make(chan struct{})
+	)
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, _ := net.SplitHostPort(addr)
+			mu.Lock()
+			refuse, connKill := dead[host], kill
+			mu.Unlock()
+			if refuse {
+				return nil, syscall.ECONNREFUSED
+			}
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return failingConn{conn, connKill}, nil
+		}
+		return transport
+	}
+	for _, staleConnRetry := range []bool{false, true} {
+		t.Run(fmt.Sprint(staleConnRetry), func(t *testing.T) {
+			var opts []Option
+			if staleConnRetry {
+				opts = append(opts, WithStaleConnRetry())
+			}
+			rt := newTestT(factory, testIPs(1, 2), opts...)
+			defer rt.Close()
+			// Use 10.0.0.1 whenever it's a candidate.
+			rt.balancer = BalancerFunc(func(_ string, candidates []net.IP) net.IP {
+				for _, ip := range candidates {
+					if ip.Equal(net.IP{10, 0, 0, 1}) {
+						return ip
+					}
+				}
+				return candidates[0]
+			})
+			mu.Lock()
+			dead = map[string]bool{}
+			kill = make(chan struct{})
+			mu.Unlock()
+
+			_, err := get(context.Background(), rt, "http://s3.example.com/")
+			require.NoError(t, err)
+
+			// 10.0.0.1's pooled connection and the peer itself die.
+			mu.Lock()
+			dead["10.0.0.1"] = true
+			close(kill)
+			kill = make(chan struct{})
+			mu.Unlock()
+
+			var h History
+			_, err = get(RecordAttempts(context.Background(), &h), rt, "http://s3.example.com/")
+			attempts := h.Attempts()
+			if !staleConnRetry {
+				require.Error(t, err)
+				require.Len(t, attempts, 1)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, attempts, 2)
+			assert.Equal(t, "10.0.0.1", attempts[0].IP.String())
+			assert.True(t, attempts[0].Reused)
+			assert.Error(t, attempts[0].Err)
+			assert.Equal(t, "10.0.0.2", attempts[1].IP.String())
+			assert.NoError(t, attempts[1].Err)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package s3transport
+
+import "time"
+
+// WithRespectDNSTTL makes T use each lookup's TTL, as reported by a TTLResolver configured
+// with WithResolverChain: the result is cached for its TTL, rather than briefly (a few
+// seconds), and its IPs remain candidates for requests only until it expires, rather than for
+// an hour after they were last seen. WithMinTTL and WithMaxTTL bound the TTLs that are
+// respected. Results without a known TTL are handled as by default. This package provides no
+// TTLResolver: the default resolver and SystemResolver don't report TTLs, so with them, this
+// option has no effect.
+func WithRespectDNSTTL() Option {
+	return func(t *T) { t.respectTTL = true }
+}
+
+// WithMinTTL makes T, with WithRespectDNSTTL, cache lookup results for at least d, even if
+// their TTLs are shorter, so that DNS setups with very short TTLs don't cause constant
+// re-resolution. Longer TTLs are honored. d <= 0 means no floor, the default.
+func WithMinTTL(d time.Duration) Option {
+	return func(t *T) { t.minTTL = d }
+}
+
+// WithMaxTTL makes T, with WithRespectDNSTTL, cache lookup results for at most d, even if
+// their TTLs are longer, so that rotated IPs aren't used for long after they change. Together
+// with WithMinTTL, it bounds how often hosts are re-resolved. d <= 0 means no cap, the default.
+func WithMaxTTL(d time.Duration) Option {
+	return func(t *T) { t.maxTTL = d }
+}
+
+// cacheTime returns how long to cache a lookup result with the given TTL, with
+// WithRespectDNSTTL.
+func (t *T) cacheTime(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return dnsCacheTime
+	}
+	if t.minTTL > 0 && ttl < t.minTTL {
+		ttl = t.minTTL
+	}
+	if t.maxTTL > 0 && ttl > t.maxTTL {
+		ttl = t.maxTTL
+	}
+	return ttl
+}
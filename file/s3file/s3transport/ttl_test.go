@@ -0,0 +1,135 @@
+package s3transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedTTLResolver is a TTLResolver that returns ips, or testIPs(1) if it's nil, with ttl,
+// and counts lookups.
+type fixedTTLResolver struct {
+	ttl     time.Duration
+	ips     []net.IP
+	lookups int
+}
+
+func (r *fixedTTLResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := r.LookupIPTTL(ctx, host)
+	return ips, err
+}
+
+func (r *fixedTTLResolver) LookupIPTTL(context.Context, string) ([]net.IP, time.Duration, error) {
+	r.lookups++
+	if r.ips != nil {
+		return r.ips, r.ttl, nil
+	}
+	return testIPs(1), r.ttl, nil
+}
+
+// lookupsAfter returns the number of lookups rt's resolver has made of host, after resolving it
+// at start and again after each of elapsed.
+func lookupsAfter(t *testing.T, rt *T, r *fixedTTLResolver, elapsed ...time.Duration) int {
+	start := time.Unix(1600000000, 0)
+	now := start
+	rt.resolver.now = func() time.Time { return now }
+	for _, d := range append([]time.Duration{0}, elapsed...) {
+		now = start.Add(d)
+		_, err := rt.resolver.LookupIP("s3.example.com")
+		require.NoError(t, err)
+	}
+	return r.lookups
+}
+
+func TestRespectDNSTTL(t *testing.T) {
+	r := &fixedTTLResolver{ttl: time.Minute}
+	rt := New(nil, WithResolverChain(r), WithRespectDNSTTL())
+	defer rt.Close()
+	assert.Equal(t, 1, lookupsAfter(t, rt, r, 59*time.Second))
+	assert.Equal(t, 2, lookupsAfter(t, rt, r, 61*time.Second))
+
+	r = &fixedTTLResolver{ttl: time.Minute}
+	rt = New(nil, WithResolverChain(r))
+	defer rt.Close()
+	assert.Equal(t, 2, lookupsAfter(t, rt, r, dnsCacheTime), "TTLs aren't respected by default")
+}
+
+func TestMinTTL(t *testing.T) {
+	r := &fixedTTLResolver{ttl: time.Second}
+	rt := New(nil, WithResolverChain(r), WithRespectDNSTTL(), WithMinTTL(30*time.Second))
+	defer rt.Close()
+	assert.Equal(t, 1, lookupsAfter(t, rt, r, 2*time.Second, 29*time.Second))
+	assert.Equal(t, 2, lookupsAfter(t, rt, r, 31*time.Second))
+
+	r = &fixedTTLResolver{ttl: time.Minute}
+	rt = New(nil, WithResolverChain(r), WithRespectDNSTTL(), WithMinTTL(30*time.Second))
+	defer rt.Close()
+	assert.Equal(t, 1, lookupsAfter(t, rt, r, 59*time.Second), "longer TTLs are honored")
+}
+
+func TestMaxTTL(t *testing.T) {
+	r := &fixedTTLResolver{ttl: time.Hour}
+	rt := New(nil, WithResolverChain(r), WithRespectDNSTTL(), WithMaxTTL(5*time.Minute))
+	defer rt.Close()
+	assert.Equal(t, 1, lookupsAfter(t, rt, r, 5*time.Minute-time.Second))
+	assert.Equal(t, 2, lookupsAfter(t, rt, r, 5*time.Minute))
+
+	r = &fixedTTLResolver{ttl: time.Second}
+	rt = New(nil, WithResolverChain(r), WithRespectDNSTTL(),
+		WithMinTTL(30*time.Second), WithMaxTTL(5*time.Minute))
+	defer rt.Close()
+	assert.Equal(t, 1, lookupsAfter(t, rt, r, 29*time.Second), "the floor still applies")
+}
+
+func TestMaxTTLExpiresIPs(t *testing.T) {
+	r := &fixedTTLResolver{ttl: time.Hour}
+	rt := New(nil, WithResolverChain(r), WithRespectDNSTTL(), WithMaxTTL(5*time.Minute))
+	defer rt.Close()
+	got := candidatesAfter(t, rt, func(d time.Duration) {
+		if d > 0 {
+			r.ips = testIPs(2)
+		}
+	}, 0, 5*time.Minute-time.Second, 5*time.Minute)
+	assert.Equal(t, testIPs(1), got[1])
+	assert.Equal(t, testIPs(2), got[2], "the capped ips are no longer candidates")
+}
+
+// candidatesAfter returns the candidate IPs of a request to rt after each of elapsed, with
+// rt's clocks stubbed. Before each request, setup is called with the elapsed time.
+func candidatesAfter(
+	t *testing.T, rt *T, setup func(time.Duration), elapsed ...time.Duration,
+) (candidates [][]net.IP) {
+	start := time.Unix(1600000000, 0)
+	now := start
+	rt.resolver.now = func() time.Time { return now }
+	rt.hostIPs.now = func() time.Time { return now }
+	for _, d := range elapsed {
+		now = start.Add(d)
+		setup(d)
+		req, err := http.NewRequest(http.MethodGet, "https://s3.example.com/", nil)
+		require.NoError(t, err)
+		ips, err := rt.resolve(req, hostKey{host: "s3.example.com"}, now)
+		require.NoError(t, err)
+		candidates = append(candidates, ips)
+	}
+	return
+}
+
+func TestRespectDNSTTLExpiresIPs(t *testing.T) {
+	r := &fixedTTLResolver{ttl: time.Minute}
+	rt := New(nil, WithResolverChain(r), WithRespectDNSTTL())
+	defer rt.Close()
+	got := candidatesAfter(t, rt, func(d time.Duration) {
+		if d > 0 {
+			r.ips = testIPs(2) // The host rotated.
+		}
+	}, 0, 59*time.Second, 61*time.Second)
+	assert.Equal(t, testIPs(1), got[0])
+	assert.Equal(t, testIPs(1), got[1], "the result is still cached")
+	assert.Equal(t, testIPs(2), got[2], "the old ips expired with their ttl")
+}
@@ -0,0 +1,58 @@
+package s3transport
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/base/sync/multierror"
+)
+
+// WithFactoryValidation makes New check a transport from the factory for settings that
+// conflict with T's design (see ValidateTransport) and log any problems. If normalize is
+// true, those problems that T can fix are also fixed in every transport the factory returns.
+// It's meant for debugging custom factories.
+func WithFactoryValidation(normalize bool) Option {
+	return func(t *T) {
+		t.validateFactory = true
+		t.normalizeFactory = normalize
+	}
+}
+
+// ValidateTransport returns an error describing transport's settings that conflict with T's
+// use of it, or nil if there are none:
+//   - ForceAttemptHTTP2: S3 doesn't support HTTP/2, and multiplexing on few connections
+//     would defeat balancing across IPs. Normalization turns it off.
+//   - TLSClientConfig.ServerName: T sets the server name to each request's host, so a fixed
+//     one is overwritten, except for IP literal hosts. Normalization clears it.
+//   - DialTLS: a custom TLS dialer bypasses the TLS configuration, including the server
+//     name, that T depends on. It can't be normalized.
+func ValidateTransport(transport *http.Transport) error {
+	errs := multierror.NewMultiError(3)
+	if transport.ForceAttemptHTTP2 {
+		errs.Add(errors.New("s3transport: transport sets ForceAttemptHTTP2"))
+	}
+	if transport.TLSClientConfig != nil && transport.TLSClientConfig.ServerName != "" {
+		errs.Add(errors.New("s3transport: transport sets TLSClientConfig.ServerName"))
+	}
+	if transport.DialTLS != nil {
+		errs.Add(errors.New("s3transport: transport sets DialTLS"))
+	}
+	return errs.Err()
+}
+
+// normalizeTransport fixes the settings of transport that ValidateTransport reports and that
+// can be fixed.
+func normalizeTransport(transport *http.Transport) {
+	transport.ForceAttemptHTTP2 = false
+	if transport.TLSClientConfig != nil {
+		transport.TLSClientConfig.ServerName = ""
+	}
+}
+
+// checkFactory logs the problems ValidateTransport finds with one of t's factory's transports.
+func (t *T) checkFactory() {
+	if err := ValidateTransport(t.factory()); err != nil {
+		log.Printf("s3transport: factory's transports conflict with s3transport: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package s3transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTransport(t *testing.T) {
+	assert.NoError(t, ValidateTransport(httpTransport.Clone()))
+
+	transport := httpTransport.Clone()
+	transport.ForceAttemptHTTP2 = true
+	err := ValidateTransport(transport)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ForceAttemptHTTP2")
+
+	transport.TLSClientConfig = &tls.Config{ServerName: "example.com"}
+	err = ValidateTransport(transport)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ForceAttemptHTTP2")
+	assert.Contains(t, err.Error(), "ServerName")
+}
+
+func TestFactoryNormalization(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	var transports []*http.Transport
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		transport.ForceAttemptHTTP2 = true
+		transports = append(transports, transport)
+		return transport
+	}
+	rt := newTestT(factory, testIPs(1), WithFactoryValidation(true))
+	defer rt.Close()
+	require.Len(t, transports, 1, "New checks one transport")
+	assert.True(t, transports[0].ForceAttemptHTTP2, "the checked transport isn't used")
+
+	_, err := get(context.Background(), rt, "http://s3.example.com/")
+	require.NoError(t, err)
+	require.Len(t, transports, 2)
+	assert.NoError(t, ValidateTransport(transports[1]))
+}
@@ -0,0 +1,135 @@
+package s3transport
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// warmRetryDelay is how long WaitWarm waits after each round of Warm before counting idle
+// connections, since connections may not have been retained (for example, because of
+// MaxIdleConnsPerHost).
+const warmRetryDelay = 100 * time.Millisecond
+
+// Warm opens connections to host (a URL host, with an optional port, served over HTTPS) in
+// advance of a burst of requests, by sending n concurrent HEAD requests for its root. The
+// requests' responses are discarded, so their status doesn't matter. Connections are
+// retained subject to the factory's transport settings, like MaxIdleConnsPerHost. Warm
+// returns after the requests finish, with the first of their errors, if any.
+func (t *T) Warm(ctx context.Context, host string, n int) error {
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			if err := t.warmOne(ctx, host); err != nil {
+				errOnce.Do(func() { firstErr = err })
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (t *T) warmOne(ctx context.Context, host string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://"+host+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// WaitWarm warms connections to host, as Warm does, until at least minConns of them are
+// idle, and so ready for new requests. It returns an error if a Warm request fails or ctx is
+// done first. The count of idle connections is approximate when other requests to host are
+// in flight.
+func (t *T) WaitWarm(ctx context.Context, host string, minConns int) error {
+	for t.idleConns(host) < minConns {
+		// Warm requests use idle connections first, so to open more, it sends one request
+		// for each connection that's wanted.
+		if err := t.Warm(ctx, host, minConns); err != nil {
+			return err
+		}
+		// The transport closes connections it doesn't retain only after their responses
+		// are returned, so they're counted again after a delay, once they're gone.
+		if err := sleep(ctx, warmRetryDelay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idleConns returns the approximate number of host's connections that are idle: those
+// open but not in use by an in-flight request.
+func (t *T) idleConns(host string) (idle int) {
+	s := t.lookupHost(host)
+	if s == nil {
+		return 0
+	}
+	s.ipsMu.Lock()
+	defer s.ipsMu.Unlock()
+	for _, is := range s.ips {
+		idle += is.idleConns()
+	}
+	return
+}
+
+// idleConns returns the approximate number of the IP's connections that are idle.
+func (is *ipState) idleConns() int {
+	if n := atomic.LoadInt64(&is.openConns) - atomic.LoadInt64(&is.inFlight); n > 0 {
+		return int(n)
+	}
+	return 0
+}
+
+// Warmth is a best-effort hint about whether an attempt will be sent on a warm connection,
+// reused from the pool, or has to dial a cold one. It's advisory: idle connections may be
+// taken by concurrent requests, or closed, before the attempt gets one. See ReportWarmth.
+type Warmth struct {
+	// Host is the request's host, and IP the address T chose for the attempt.
+	Host string
+	IP   net.IP
+	// IdleConns is the approximate number of idle connections to IP when it was chosen.
+	IdleConns int
+}
+
+// Warm reports whether the attempt is likely to reuse a connection.
+func (w Warmth) Warm() bool { return w.IdleConns > 0 }
+
+type warmthKey struct{}
+
+// ReportWarmth returns a context that makes T call f with a Warmth hint for each attempt of a
+// request using the context, after choosing the attempt's IP and before sending it. Callers
+// may use it, for example, to set tighter deadlines for warm requests. f must not block.
+func ReportWarmth(ctx context.Context, f func(Warmth)) context.Context {
+	return context.WithValue(ctx, warmthKey{}, f)
+}
+
+// reportWarmth calls ctx's ReportWarmth func, if any, for an attempt to host's ip.
+func (t *T) reportWarmth(ctx context.Context, host string, ip net.IP) {
+	f, _ := ctx.Value(warmthKey{}).(func(Warmth))
+	if f == nil {
+		return
+	}
+	w := Warmth{Host: host, IP: ip}
+	if s := t.lookupHost(host); s != nil {
+		if is := s.lookupIP(ip); is != nil {
+			w.IdleConns = is.idleConns()
+		}
+	}
+	f(w)
+}
@@ -0,0 +1,86 @@
+package s3transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tlsFactory returns a factory whose transports dial srv, a TLS server, without verifying
+// its certificate.
+func tlsFactory(srv *httptest.Server, maxIdlePerHost int) func() *http.Transport {
+	return func() *http.Transport {
+		return &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, srv.Listener.Addr().String())
+			},
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+			MaxIdleConnsPerHost: maxIdlePerHost,
+		}
+	}
+}
+
+func slowHandler(d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(d) })
+}
+
+func TestWaitWarm(t *testing.T) {
+	srv := httptest.NewTLSServer(slowHandler(20 * time.Millisecond))
+	defer srv.Close()
+	rt := newTestT(tlsFactory(srv, 4), testIPs(1, 2))
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, rt.WaitWarm(ctx, "s3.example.com", 6))
+	assert.True(t, rt.idleConns("s3.example.com") >= 6, "%d", rt.idleConns("s3.example.com"))
+	var open int64
+	for _, s := range rt.ConnStats("s3.example.com") {
+		open += s.OpenConns
+	}
+	assert.True(t, open >= 6, "%d", open)
+
+	require.NoError(t, rt.WaitWarm(ctx, "s3.example.com", 6), "already warm")
+}
+
+func TestWaitWarmCanceled(t *testing.T) {
+	srv := httptest.NewTLSServer(slowHandler(0))
+	defer srv.Close()
+	// Only one connection per IP is retained, so two can never be idle.
+	rt := newTestT(tlsFactory(srv, 1), testIPs(1))
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Error(t, rt.WaitWarm(ctx, "s3.example.com", 2))
+	assert.Error(t, ctx.Err())
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestReportWarmth(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	rt := newTestT(srv.factory, testIPs(1))
+	defer rt.Close()
+
+	var hints []Warmth
+	ctx := ReportWarmth(context.Background(), func(w Warmth) { hints = append(hints, w) })
+	for i := 0; i < 2; i++ {
+		_, err := get(ctx, rt, "http://s3.example.com/")
+		require.NoError(t, err)
+	}
+	require.Len(t, hints, 2)
+	assert.Equal(t, "s3.example.com", hints[0].Host)
+	assert.Equal(t, testIPs(1)[0].String(), hints[0].IP.String())
+	assert.False(t, hints[0].Warm(), "nothing has connected yet")
+	assert.True(t, hints[1].Warm(), "the first request's connection is idle")
+}
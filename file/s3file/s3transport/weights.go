@@ -0,0 +1,51 @@
+package s3transport
+
+import (
+	"net"
+	"net/http"
+)
+
+// minWeight is the least weight WithAdaptiveWeights gives an IP, so that a failing IP still
+// gets occasional requests, and can recover.
+const minWeight = 0.01
+
+// WithAdaptiveWeights makes T choose among a host's IPs in proportion to weights that adapt
+// to each IP's outcomes: an IP's weight, initially 1, is multiplied by decay (between 0 and 1)
+// when a request to it fails with a transport error or a retriable status (429 or 5xx), and
+// increased by recovery, up to 1, when one succeeds. Weights never fall below 1% of the
+// initial weight. This degrades failing IPs gradually, rather than ejecting them. Large
+// transfers balanced by WithBandwidthBalancing don't use the weights. By default, IPs are
+// chosen uniformly.
+func WithAdaptiveWeights(decay, recovery float64) Option {
+	return func(t *T) { t.weightDecay, t.weightRecovery = decay, recovery }
+}
+
+// chooseByWeight picks one of host's candidates at random, weighted by their adaptive weights.
+func (t *T) chooseByWeight(host string, candidates []net.IP) net.IP {
+	return t.chooseByScore(host, candidates, func(scores ipScores) float64 {
+		if !scores.weighted {
+			return 1
+		}
+		return scores.weight
+	})
+}
+
+// recordOutcome adjusts the adaptive weight of host's ip for the outcome, resp or err, of req.
+func (t *T) recordOutcome(req *http.Request, host string, ip net.IP, resp *http.Response, err error) {
+	t.recordScore(req, host, ip, resp, err, func(is *ipState, success bool) {
+		if !is.weighted {
+			is.weighted, is.weight = true, 1
+		}
+		if success {
+			is.weight += t.weightRecovery
+			if is.weight > 1 {
+				is.weight = 1
+			}
+		} else {
+			is.weight *= t.weightDecay
+			if is.weight < minWeight {
+				is.weight = minWeight
+			}
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package s3transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveWeights(t *testing.T) {
+	srv := newTestServer(okHandler())
+	defer srv.Close()
+	// Three of every four connections to 10.0.0.1 fail.
+	var dials int32
+	factory := func() *http.Transport {
+		transport := srv.factory()
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if host, _, _ := net.SplitHostPort(addr); host == "10.0.0.1" && atomic.AddInt32(&dials, 1)%4 != 0 {
+				return nil, errors.New("connection refused")
+			}
+			return dial(ctx, network, addr)
+		}
+		return transport
+	}
+	rt := newTestT(factory, testIPs(1, 2), WithAdaptiveWeights(0.5, 0.1), WithDisableKeepAlives())
+	defer rt.Close()
+
+	const n = 2000
+	var picks, failures [2]int
+	for i := 0; i < n; i++ {
+		var h History
+		_, err := get(RecordAttempts(context.Background(), &h), rt, "http://s3.example.com/")
+		if i < n/2 {
+			continue // Let the weights settle.
+		}
+		if h.Attempts()[0].IP.Equal(testIPs(1)[0]) {
+			picks[0]++
+			if err != nil {
+				failures[0]++
+			}
+		} else {
+			picks[1]++
+			assert.NoError(t, err)
+		}
+	}
+	assert.True(t, picks[0] < n/2/5, "the failing IP is picked less: %v", picks)
+	assert.NotZero(t, picks[0], "but still picked")
+	assert.NotEqual(t, picks[0], failures[0], "and occasionally succeeds")
+}
+
+func TestAdaptiveWeightsBounds(t *testing.T) {
+	rt := newTestT(httpTransport.Clone, nil, WithAdaptiveWeights(0.5, 0.25))
+	defer rt.Close()
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := testIPs(1)[0]
+	weight := func() float64 { return rt.host("s3.example.com").ip(ip).weight }
+	for i := 0; i < 20; i++ {
+		rt.recordOutcome(req, "s3.example.com", ip, nil, errors.New("failed"))
+	}
+	assert.Equal(t, minWeight, weight())
+	rt.recordOutcome(req, "s3.example.com", ip, &http.Response{StatusCode: http.StatusOK}, nil)
+	assert.InDelta(t, minWeight+0.25, weight(), 1e-9)
+	for i := 0; i < 10; i++ {
+		rt.recordOutcome(req, "s3.example.com", ip, &http.Response{StatusCode: http.StatusOK}, nil)
+	}
+	assert.Equal(t, 1.0, weight())
+	rt.recordOutcome(req, "s3.example.com", ip, &http.Response{StatusCode: http.StatusServiceUnavailable}, nil)
+	assert.Equal(t, 0.5, weight())
+}
+
+func TestScoreRefreshInterval(t *testing.T) {
+	const refresh = time.Minute
+	rt := newTestT(httpTransport.Clone, nil, WithAdaptiveWeights(0.5, 0.1), WithScoreRefreshInterval(refresh))
+	defer rt.Close()
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	ip := testIPs(1)[0]
+	is := rt.host("s3.example.com").ip(ip)
+	scores := func(now time.Time) ipScores {
+		is.mu.Lock()
+		defer is.mu.Unlock()
+		return is.scores(now, refresh)
+	}
+
+	start := time.Unix(1600000000, 0)
+	rt.recordThroughput("s3.example.com", ip, 1000, time.Second)
+	assert.Equal(t, 1000.0, scores(start).throughput)
+	for i := 1; i < 60; i++ {
+		rt.recordThroughput("s3.example.com", ip, 2000, time.Second)
+		rt.recordOutcome(req, "s3.example.com", ip, nil, errors.New("failed"))
+		s := scores(start.Add(time.Duration(i) * time.Second))
+		assert.Equal(t, 1000.0, s.throughput, "%d", i)
+		assert.False(t, s.weighted)
+	}
+	s := scores(start.Add(refresh))
+	assert.InDelta(t, 2000, s.throughput, 1)
+	assert.Equal(t, minWeight, s.weight)
+}
+
+func TestScoreRefreshIntervalBalancing(t *testing.T) {
+	rt := newTestT(httpTransport.Clone, nil, WithAdaptiveWeights(0.5, 0.1), WithScoreRefreshInterval(time.Hour))
+	defer rt.Close()
+	req, err := http.NewRequest(http.MethodGet, "http://s3.example.com/", nil)
+	require.NoError(t, err)
+	ips := testIPs(1, 2)
+	for _, ip := range ips {
+		rt.recordOutcome(req, "s3.example.com", ip, &http.Response{StatusCode: http.StatusOK}, nil)
+	}
+	_ = rt.chooseByWeight("s3.example.com", ips) // Take the snapshot.
+	for i := 0; i < 20; i++ {
+		rt.recordOutcome(req, "s3.example.com", ips[0], nil, errors.New("failed"))
+	}
+	var picked0 int
+	const n = 1000
+	for i := 0; i < n; i++ {
+		if rt.chooseByWeight("s3.example.com", ips).Equal(ips[0]) {
+			picked0++
+		}
+	}
+	assert.InDelta(t, n/2, picked0, n/10, "the failures aren't seen until the next refresh")
+}
@@ -44,6 +44,7 @@ require (
 	github.com/google/uuid v1.1.2
 	github.com/shirou/gopsutil v2.19.9+incompatible
 	go.uber.org/zap v1.16.0
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 	google.golang.org/api v0.10.0
 	gopkg.in/inf.v0 v0.9.1 // indirect
 	k8s.io/api v0.0.0-20181213150558-05914d821849